@@ -5,10 +5,8 @@ import (
 	"os"
 )
 
-var debug = false
-
 func dbg(msg string, args ...interface{}) {
-	if debug {
+	if *optDebug {
 		fmt.Fprintf(os.Stderr, msg, args...)
 	}
 }
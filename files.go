@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdinName is the file name that stands for the standard input.
+const stdinName = "-"
+
+// collectFiles expands the file arguments into the list of files to process. Arguments
+// that don't name an existing file are treated as glob patterns, and if `recursive` is set the
+// files inside directories are included. Problems with individual arguments are reported
+// to stderr and returned as the error, but don't stop the other arguments from being collected.
+func collectFiles(args []string, recursive bool) (files []string, err error) {
+	fail := func(e error) {
+		fmt.Fprintf(os.Stderr, "%v\n", e)
+		if err == nil {
+			err = e
+		}
+	}
+
+	for _, arg := range args {
+		if arg == stdinName {
+			files = append(files, arg)
+			continue
+		}
+
+		paths := []string{arg}
+		if _, serr := os.Stat(arg); serr != nil && isGlob(arg) {
+			paths, serr = filepath.Glob(arg)
+			if serr != nil {
+				fail(fmt.Errorf("Invalid pattern '%s': %v", arg, serr))
+				continue
+			}
+			if len(paths) == 0 {
+				fail(fmt.Errorf("%s: No files match the pattern", arg))
+				continue
+			}
+		}
+
+		for _, path := range paths {
+			info, serr := os.Stat(path)
+			if serr != nil {
+				fail(serr)
+				continue
+			}
+
+			if !info.IsDir() {
+				files = append(files, path)
+				continue
+			}
+
+			if !recursive {
+				fail(fmt.Errorf("%s: Is a directory", path))
+				continue
+			}
+
+			werr := filepath.WalkDir(path, func(p string, d fs.DirEntry, e error) error {
+				if e != nil {
+					fail(e)
+					return nil
+				}
+				if d.Type().IsRegular() {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if werr != nil {
+				fail(werr)
+			}
+		}
+	}
+	return
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestCollectFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.log", "b.log", "c.txt", "sub/d.log"} {
+		path := filepath.Join(dir, f)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := os.WriteFile(path, []byte(f), 0600); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		recursive bool
+		expected  []string
+		err       bool
+	}{
+		{
+			name:     "files",
+			args:     []string{"a.log", "c.txt"},
+			expected: []string{"a.log", "c.txt"},
+		},
+		{
+			name:     "glob",
+			args:     []string{"*.log"},
+			expected: []string{"a.log", "b.log"},
+		},
+		{
+			name:     "stdin",
+			args:     []string{"-", "c.txt"},
+			expected: []string{"-", "c.txt"},
+		},
+		{
+			name:      "recursive",
+			args:      []string{"."},
+			recursive: true,
+			expected:  []string{"a.log", "b.log", "c.txt", "sub/d.log"},
+		},
+		{
+			name:     "directory without recursion",
+			args:     []string{"sub", "c.txt"},
+			expected: []string{"c.txt"},
+			err:      true,
+		},
+		{
+			name:     "missing file",
+			args:     []string{"missing", "*.none"},
+			expected: nil,
+			err:      true,
+		},
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := collectFiles(tc.args, tc.recursive)
+			if tc.err != (err != nil) {
+				t.Fatalf("Expected error to be %v but got %v", tc.err, err)
+			}
+
+			for i := range files {
+				files[i] = filepath.ToSlash(files[i])
+			}
+			sort.Strings(files)
+
+			if len(files) != len(tc.expected) {
+				t.Fatalf("Expected %#v but got %#v", tc.expected, files)
+			}
+			for i := range files {
+				if files[i] != tc.expected[i] {
+					t.Fatalf("Expected %#v but got %#v", tc.expected, files)
+				}
+			}
+		})
+	}
+}
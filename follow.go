@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// followInterval is how often a followed file is checked for new data once its end has been reached.
+const followInterval = 250 * time.Millisecond
+
+// followReader reads a file like tail -F: at the end of the file it waits for more data to be
+// appended instead of returning EOF. If the file is truncated, or replaced by a new file of the
+// same name as happens when a log is rotated, reading starts again at the beginning of the file.
+type followReader struct {
+	fname    string
+	file     *os.File
+	off      int64
+	interval time.Duration
+}
+
+func newFollowReader(fname string, file *os.File) *followReader {
+	return &followReader{fname: fname, file: file, interval: followInterval}
+}
+
+func (r *followReader) Read(p []byte) (n int, err error) {
+	for {
+		n, err = r.file.Read(p)
+		r.off += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return
+		}
+
+		var reopened bool
+		reopened, err = r.reopenIfReplaced()
+		if err != nil {
+			return
+		}
+		if !reopened {
+			time.Sleep(r.interval)
+		}
+	}
+}
+
+// reopenIfReplaced checks whether the file has been truncated or replaced since it was opened,
+// and if so starts reading it again from the beginning.
+func (r *followReader) reopenIfReplaced() (bool, error) {
+	info, err := os.Stat(r.fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The file has been moved away, and the new one hasn't been created yet.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	cur, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(info, cur) {
+		dbg("followReader: %s has been replaced\n", r.fname)
+		file, err := os.Open(r.fname)
+		if err != nil {
+			return false, err
+		}
+		r.file.Close()
+		r.file = file
+		r.off = 0
+		return true, nil
+	}
+
+	if cur.Size() < r.off {
+		dbg("followReader: %s has been truncated\n", r.fname)
+		if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		r.off = 0
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Close closes the file currently being read.
+func (r *followReader) Close() error {
+	return r.file.Close()
+}
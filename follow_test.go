@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowReader(t *testing.T) {
+	tests := []struct {
+		name string
+		// change modifies the file after its initial contents have been read.
+		change   func(t *testing.T, fname string)
+		expected string
+	}{
+		{
+			name: "append",
+			change: func(t *testing.T, fname string) {
+				f, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatalf("Error opening file: %v", err)
+				}
+				defer f.Close()
+				f.WriteString("three\n")
+			},
+			expected: "one\ntwo\nthree\n",
+		},
+		{
+			name: "truncate",
+			change: func(t *testing.T, fname string) {
+				os.WriteFile(fname, []byte("new\n"), 0644)
+			},
+			expected: "one\ntwo\nnew\n",
+		},
+		{
+			name: "replace",
+			change: func(t *testing.T, fname string) {
+				os.Rename(fname, fname+".1")
+				os.WriteFile(fname, []byte("rotated\n"), 0644)
+			},
+			expected: "one\ntwo\nrotated\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "file.log")
+			if err := os.WriteFile(fname, []byte("one\ntwo\n"), 0644); err != nil {
+				t.Fatalf("Error writing file: %v", err)
+			}
+
+			file, err := os.Open(fname)
+			if err != nil {
+				t.Fatalf("Error opening file: %v", err)
+			}
+			rdr := newFollowReader(fname, file)
+			rdr.interval = time.Millisecond
+			defer rdr.Close()
+
+			buf := make([]byte, len(tc.expected))
+			if _, err := io.ReadFull(rdr, buf[:8]); err != nil {
+				t.Fatalf("Error reading file: %v", err)
+			}
+
+			tc.change(t, fname)
+
+			if _, err := io.ReadFull(rdr, buf[8:]); err != nil {
+				t.Fatalf("Error reading file: %v", err)
+			}
+
+			if string(buf) != tc.expected {
+				t.Fatalf("Actual '%s' does not match expected '%s'", string(buf), tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffwilliams/srex/srex"
+)
+
+// checkInPlace returns an error if the output of `prog` can't replace the files it's run on.
+// Only the output of a program that edits and prints nothing else is the whole input, and
+// `conflicts` are the options given that would change the form of that output.
+func checkInPlace(prog *srex.Program, conflicts []string) error {
+	if err := prog.CheckEditsOnly(); err != nil {
+		return fmt.Errorf("Files can't be edited in place: %v", err)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Files can't be edited in place with %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// inPlaceConflicts returns the options given that change the form of the output so that it's no
+// longer the edited input.
+func inPlaceConflicts() (conflicts []string) {
+	if *optChanged {
+		conflicts = append(conflicts, "--changed")
+	}
+	if *optTemplate != "" {
+		conflicts = append(conflicts, "-o")
+	}
+	if *optNull {
+		conflicts = append(conflicts, "-z")
+	}
+	if *optNetstring {
+		conflicts = append(conflicts, "--netstring")
+	}
+	if optColor.mode == "always" {
+		conflicts = append(conflicts, "--color=always")
+	}
+	return
+}
+
+// editInPlace replaces the contents of the file `fname` with the output of `write`. The
+// output is written to a temporary file in the same directory which is then renamed over
+// the original, so that the file is never left half-written. If `suffix` is not empty the
+// original file is kept with that suffix appended to its name.
+func editInPlace(fname, suffix string, write func(out io.Writer) error) (err error) {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fname), "."+filepath.Base(fname)+".srex*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if suffix != "" {
+		if err = backup(fname, fname+suffix); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), fname)
+}
+
+// backup makes `bak` refer to the current contents of `fname`. A hard link is used when
+// possible so that the backup is the original file itself.
+func backup(fname, bak string) error {
+	os.Remove(bak)
+	if err := os.Link(fname, bak); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(bak)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
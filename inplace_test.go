@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffwilliams/srex/srex"
+)
+
+func TestEditInPlace(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "file")
+
+	err := os.WriteFile(fname, []byte("original"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	err = editInPlace(fname, ".bak", func(out io.Writer) error {
+		_, err := io.WriteString(out, "edited")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error editing in place: %v", err)
+	}
+
+	checkFile(t, fname, "edited")
+	checkFile(t, fname+".bak", "original")
+
+	err = editInPlace(fname, "", func(out io.Writer) error {
+		io.WriteString(out, "partial")
+		return fmt.Errorf("failed")
+	})
+	if err == nil {
+		t.Fatalf("Expected editing in place to fail")
+	}
+
+	checkFile(t, fname, "edited")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error reading dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected the temporary file to be removed, but the directory contains %d files", len(entries))
+	}
+}
+
+func checkFile(t *testing.T, fname, expected string) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	if string(buf) != expected {
+		t.Fatalf("Expected '%s' in %s but got '%s'", expected, fname, string(buf))
+	}
+}
+
+func TestExpandInPlaceArgs(t *testing.T) {
+	args := expandInPlaceArgs([]string{"-i", "-i.bak", "-d", "--", "-i.orig"})
+	expected := []string{"-i", "--in-place=.bak", "-d", "--", "-i.orig"}
+
+	if fmt.Sprint(args) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %#v but got %#v", expected, args)
+	}
+}
+
+func TestCheckInPlace(t *testing.T) {
+	const original = "a\nb\n"
+
+	tests := []struct {
+		name      string
+		program   string
+		conflicts []string
+		expected  string
+	}{
+		{name: "substitute", program: `x/.*\n/ s/a/X/`, expected: "X\nb\n"},
+		{name: "edits in block", program: `x/.*\n/ { g/a/ d g/b/ s/b/B/ }`, expected: "B\n"},
+		{name: "edit in nested block", program: `x/.*\n/ { g/a/ { s/a/X/ } }`, expected: "X\nb\n"},
+		{name: "no editor", program: `x/.*\n/ g/a/`},
+		{name: "print", program: `x/.*\n/ p`},
+		{name: "line numbers", program: `x/.*\n/ =`},
+		{name: "line numbers and edit", program: `x/.*\n/ { = s/a/X/g }`},
+		{name: "count and edit", program: `x/.*\n/ { # s/a/X/ }`},
+		{name: "aggregate and edit", program: `x/.*\n/ { k/(.)/ s/a/X/ }`},
+		{name: "format and edit", program: `x/.*\n/ { f/{text}/ s/a/X/ }`},
+		{name: "implicit print and edit", program: `x/.*\n/ { s/a/X/ g/b/ }`},
+		{name: "edit after n", program: `x/.*\n/ n[0] s/a/X/`},
+		{name: "edit after o", program: `x/.*\n/ o s/a/X/`},
+		{name: "edit after u", program: `x/.*\n/ u s/a/X/`},
+		{name: "edit after r", program: `x/.*\n/ r s/a/X/`},
+		{name: "changed", program: `x/.*\n/ s/a/X/`, conflicts: []string{"--changed"}},
+		{name: "template", program: `x/.*\n/ s/a/X/`, conflicts: []string{"-o"}},
+		{name: "null and netstring", program: `x/.*\n/ s/a/X/`, conflicts: []string{"-z", "--netstring"}},
+		{name: "color", program: `x/.*\n/ s/a/X/`, conflicts: []string{"--color=always"}},
+	}
+
+	optInPlace.enabled = true
+	defer func() {
+		optInPlace = inPlaceValue{}
+	}()
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(fname, []byte(original), 0600); err != nil {
+				t.Fatalf("Error writing file: %v", err)
+			}
+
+			// Edit the file the way main does, if checkInPlace allows it.
+			prog := srex.MustCompile(tc.program)
+			err := checkInPlace(prog, tc.conflicts)
+			if err == nil {
+				_, err = process(fname, prog)
+			}
+
+			if tc.expected == "" {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				checkFile(t, fname, original)
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error but got %v", err)
+			}
+			checkFile(t, fname, tc.expected)
+		})
+	}
+}
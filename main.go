@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"regexp"
-	"unicode"
 
+	"github.com/jeffwilliams/srex/srex"
 	"github.com/ogier/pflag"
 )
 
@@ -16,26 +14,109 @@ import (
 
 func init() {
 	pflag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] [file] <commands>\n", os.Args[0])
-		fmt.Printf("Apply structural regular expressions to the file or stdin, like in sam, and print the result to stdout. Supported commands:\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <commands> [file...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] -f <script> [file...]\n", os.Args[0])
+		fmt.Printf("Apply structural regular expressions to the files or stdin, like in sam, and print the\n")
+		fmt.Printf("result to stdout. Supported commands:\n\n")
 		fmt.Printf("  x/pattern/ (looping over match)\n")
 		fmt.Printf("  y/pattern/ (looping over not match)\n")
 		fmt.Printf("  z/pattern/ (looping over match plus everything after not including next match)\n")
 		fmt.Printf("  g/pattern/ (selecting matching objects)\n")
 		fmt.Printf("  v/pattern/ (selecting non-matching objects)\n")
+		fmt.Printf("  w/pattern/ op value (selecting objects where the text captured by the first group of pattern, or\n")
+		fmt.Printf("      its match, compares to value. op is <, <=, >, >=, == or !=. Numbers, times such as 09:08:00 or\n")
+		fmt.Printf("      2023-10-01 09:08:00, and other text are compared as such. Quote a value containing spaces)\n")
 		fmt.Printf("  n[indexes] (select only the ranges with the specified indexes. Valid values:)\n")
-		fmt.Printf("     N   a single number selects the range N only. Ranges are counted starting from 0. If N is negative it specifies counts from the last element instead\n")
+		fmt.Printf("     N   a single number selects the range N only. Ranges are counted starting from 0. If N is\n")
+		fmt.Printf("         negative it specifies counts from the last element instead\n")
 		fmt.Printf("     N:M  select ranges who's index is >= N and <= M. M may be negative.\n")
 		fmt.Printf("     N:     select ranges who's index is >= N\n")
+		fmt.Printf("  o, o/pattern/[n][r] (sort the ranges by their text, or the text captured by the first group of\n")
+		fmt.Printf("      pattern. n sorts numerically and r in reverse. The ranges are passed on at the end of the\n")
+		fmt.Printf("      input)\n")
+		fmt.Printf("  u, u/pattern/ (only select the first range with each text, or each text captured by the first\n")
+		fmt.Printf("      group of pattern)\n")
+		fmt.Printf("  k/pattern/, k:field/pattern/ (count the ranges by the text captured by the first group of pattern,\n")
+		fmt.Printf("      and print each key and its count at the end of the input. With a field, the name or number of\n")
+		fmt.Printf("      a group, also print the least, greatest and sum of the numbers in the field. This command is\n")
+		fmt.Printf("      terminal.)\n")
+		fmt.Printf("  r (reverse the order of the ranges. The ranges are passed on at the end of the input)\n")
+		fmt.Printf("  s/pattern/replacement/[g] (substitute the first, or with g every, match of pattern in the range. &\n")
+		fmt.Printf("      and \\1-\\9 in the replacement refer to the match and its groups, and it may use the escapes\n")
+		fmt.Printf("      of c. This command is terminal.)\n")
+		fmt.Printf("  c/text/ (change the range to text, which may use the escapes of -o, such as \\n and \\t, and \\/ for\n")
+		fmt.Printf("      a slash. This command is terminal.)\n")
+		fmt.Printf("  a/text/ (append text after the range. This command is terminal.)\n")
+		fmt.Printf("  i/text/ (insert text before the range. This command is terminal.)\n")
+		fmt.Printf("  d (delete the range. This command is terminal.)\n")
 		fmt.Printf("  p (print the range. This is the default behaviour. This command is terminal.)\n")
 		fmt.Printf("  = (print the file and line numbers of ranges. This command is terminal.)\n")
+		fmt.Printf("  =# (print the file and offsets of ranges, as #100,#120. This command is terminal.)\n")
+		fmt.Printf("  =+ (print the file, lines and columns of ranges, as file:12:5-14:3. This command is terminal.)\n")
+		fmt.Printf("  f/template/ (print the template for each range, such as f/{line}: {opc|upper}/. See -o for the\n")
+		fmt.Printf("      placeholders and escapes. This command is terminal.)\n")
+		fmt.Printf("  # (count the ranges that pass through it, and print the count once all of the input has been read)\n")
+		fmt.Printf("  { ... } (run each pipeline in the braces on the range. A pipeline in a block ends after a terminal\n")
+		fmt.Printf("      command. This command is terminal.)\n")
 		fmt.Printf("\n")
-		fmt.Printf("Commands can be composed into a pipeline of commands like so:")
-		fmt.Printf("x/pattern/ g/pattern/ n[5]")
+		fmt.Printf("The commands may start with a sam address, which selects the part of the input the\n")
+		fmt.Printf("commands apply to:\n")
+		fmt.Printf("  n (line n), #n (byte offset n), /pattern/ (next match of pattern), ?pattern? (previous match of\n")
+		fmt.Printf("      pattern), $ (the end), . (the start)\n")
+		fmt.Printf("  a1+a2, a1-a2 (a2 evaluated forward from the end, or backward from the start, of a1. n counts\n")
+		fmt.Printf("      lines)\n")
+		fmt.Printf("  a1,a2 (from the start of a1 to the end of a2), a1;a2 (like a1,a2 but a2 is evaluated starting at\n")
+		fmt.Printf("      a1)\n")
 		fmt.Printf("\n")
-		fmt.Printf("Options:")
-		fmt.Printf("  -s <sep>, --separator <sep>: Print the separator <sep> between matches. <sep> may contain \\n to represent a newline.")
-		fmt.Printf("  -d, --debug: Print debug statements to stderr")
+		fmt.Printf("Commands can be composed into a pipeline of commands like so:\n")
+		fmt.Printf("  x/pattern/ g/pattern/ n[5]\n")
+		fmt.Printf("\n")
+		fmt.Printf("The exit status is 0 if anything was printed or changed, 1 if nothing was, and 2 if there\n")
+		fmt.Printf("was an error.\n\n")
+		fmt.Printf("Options:\n")
+		fmt.Printf("  -f <script>, --file <script>: Read the commands from the file <script> instead of the first\n")
+		fmt.Printf("      argument. The commands may be spread over several lines, and a line starting with a # that\n")
+		fmt.Printf("      isn't followed by a digit is a comment, so the script may start with #!/usr/bin/env -S srex -f\n")
+		fmt.Printf("  -s <sep>, --separator <sep>: Print the separator <sep> between matches. <sep> may contain the\n")
+		fmt.Printf("      escapes of a Go string, such as \\n, \\t, \\0 and \\x1f.\n")
+		fmt.Printf("  -d, --debug: Print debug statements to stderr\n")
+		fmt.Printf("  --changed: When editing, only print the changed ranges instead of the whole edited input\n")
+		fmt.Printf("  -i[suffix], --in-place[=suffix]: Write the output back to the file instead of stdout. If suffix is\n")
+		fmt.Printf("      given, keep a backup of the original file with the suffix appended to its name.\n")
+		fmt.Printf("  -r, --recursive: Process the files in directories, recursively\n")
+		fmt.Printf("  -H, --with-filename: Print the file name before each match. This is the default when there is more\n")
+		fmt.Printf("      than one file\n")
+		fmt.Printf("  -h, --no-filename: Never print file names before matches\n")
+		fmt.Printf("  -F, --follow: Keep reading the file as it grows, like tail -F, printing each match once it is\n")
+		fmt.Printf("      complete. The file is read again from the start if it is truncated or replaced.\n")
+		fmt.Printf("  -z, --null: Treat the input as lines that end with NUL bytes instead of newlines, for line\n")
+		fmt.Printf("      addresses and the = command, and follow each match with a NUL byte instead of printing the\n")
+		fmt.Printf("      separator between matches. This suits file names from find -print0 and output to xargs -0\n")
+		fmt.Printf("  --netstring: Print each match as a netstring: its length in bytes, a colon, the match and a comma.\n")
+		fmt.Printf("      Programs reading the output can then split it into matches whatever bytes they contain\n")
+		fmt.Printf("  -c, --count: Instead of the ranges, print the number of ranges that reach the end of the pipeline\n")
+		fmt.Printf("      for each file, followed by the total when there is more than one file. The counts of #\n")
+		fmt.Printf("      commands are still printed\n")
+		fmt.Printf("  -A N, --after-context N: Print the N records after each record that has output. The records are\n")
+		fmt.Printf("      the ranges of the first command, which must be x, y or z, so after x/record/ g/ERROR/ the\n")
+		fmt.Printf("      records around each record that contains ERROR are printed. Groups of records that aren't next\n")
+		fmt.Printf("      to each other are separated by a line containing --\n")
+		fmt.Printf("  -B N, --before-context N: Print the N records before each record that has output\n")
+		fmt.Printf("  -C N, --context N: Print the N records before and after each record that has output\n")
+		fmt.Printf("  --runes: Count the offsets printed by =# and the columns printed by =+ and --json in runes, which\n")
+		fmt.Printf("      are UTF-8 encoded characters, instead of bytes\n")
+		fmt.Printf("  --color[=when]: Highlight the part of each printed range that the last regexp matched, such as the\n")
+		fmt.Printf("      part matched by g in x/record/ g/ERROR/. when is auto, always or never; --color alone means\n")
+		fmt.Printf("      auto, which highlights the matches when the output is a terminal\n")
+		fmt.Printf("  -o <template>, --template <template>: Print each match through the template instead of as it is,\n")
+		fmt.Printf("      followed by a newline. In the template {text} is the match, {file} the file name, {line} the\n")
+		fmt.Printf("      line the match starts on, {start} and {end} its byte offsets, {1} and so on the groups of the\n")
+		fmt.Printf("      last regexp, and {name} a group named name by any of the regexps with (?P<name>...). {{ and }}\n")
+		fmt.Printf("      stand for braces, and the escapes of a Go string such as \\t may be used. {end_line} is the\n")
+		fmt.Printf("      line the match ends on and {index} the number of matches before it. A placeholder may be\n")
+		fmt.Printf("      followed by the filters |trim, |upper, |lower, |oneline and |quote, such as {text|oneline}\n")
+		fmt.Printf("  --json: Print each match as a JSON object on its own line, with the file name, byte offsets, line\n")
+		fmt.Printf("      numbers, text and regexp capture groups of the match\n")
 
 		pflag.PrintDefaults()
 	}
@@ -44,341 +125,322 @@ func init() {
 func main() {
 	var err error
 
-	pflag.Parse()
-	debug = *optDebug
+	pflag.CommandLine.Parse(expandInPlaceArgs(os.Args[1:]))
 
 	dbg("Command line positional arguments after parsing: %#v\n", pflag.Args())
 
-	*optSep, err = replaceEscapes(*optSep)
+	*optSep, err = srex.Unescape(*optSep)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid escape character in separator\n")
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Invalid separator: %v\n", err)
+		os.Exit(exitError)
 	}
 
-	if len(pflag.Args()) < 1 {
-		fmt.Fprintf(os.Stderr, "A filename or command must be specified\n")
-		os.Exit(1)
+	if *optTemplate != "" {
+		template, err = parseTemplate(*optTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid template: %v\n", err)
+			os.Exit(exitError)
+		}
 	}
 
-	var commands, fname string
+	prog, args, err := compile(pflag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitError)
+	}
+	prog.NullLines = *optNull
+	prog.Runes = *optRunes
+	prog.Before, prog.After = *optContext, *optContext
+	if flagGiven("before-context") {
+		prog.Before = *optBefore
+	}
+	if flagGiven("after-context") {
+		prog.After = *optAfter
+	}
+	if prog.Before < 0 || prog.After < 0 {
+		fmt.Fprintf(os.Stderr, "The number of context records can't be negative\n")
+		os.Exit(exitError)
+	}
+	if prog.Before > 0 || prog.After > 0 {
+		if err := prog.CheckContext(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitError)
+		}
+	}
 
-	if len(pflag.Args()) == 1 {
-		commands = pflag.Args()[0]
-	} else {
-		fname = pflag.Args()[0]
-		commands = pflag.Args()[1]
+	files, err := collectFiles(args, *optRecursive)
+	failed := err != nil
+
+	showFilenames = len(files) > 1 || *optRecursive
+	if *optWithFilename {
+		showFilenames = true
+	} else if *optNoFilename {
+		showFilenames = false
 	}
 
-	process(fname, commands)
+	if len(args) == 0 {
+		files = []string{stdinName}
+	}
 
-	os.Exit(0)
-}
+	if *optFollow && (len(files) > 1 || optInPlace.enabled) {
+		fmt.Fprintf(os.Stderr, "Only a single file can be followed, and it can't be edited in place\n")
+		os.Exit(exitError)
+	}
 
-func process(fname, commands string) error {
-	if fname != "" {
-		file, err := os.Open(fname)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "When two arguments are specified, the first argument must be a filename\n")
-			os.Exit(1)
-		}
+	if *optJSON && optInPlace.enabled {
+		fmt.Fprintf(os.Stderr, "JSON output can't be written in place\n")
+		os.Exit(exitError)
+	}
 
-		err = processFile(fname, file, commands, *optSep)
-		if err != nil {
+	if optInPlace.enabled {
+		if err := checkInPlace(prog, inPlaceConflicts()); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			os.Exit(exitError)
 		}
-	} else {
-		err := processStdin(commands, *optSep)
+	}
+
+	if *optJSON && template != nil {
+		fmt.Fprintf(os.Stderr, "JSON output can't be formatted with a template\n")
+		os.Exit(exitError)
+	}
+
+	if *optCount && (*optFollow || optInPlace.enabled) {
+		fmt.Fprintf(os.Stderr, "Counts can't be printed when following a file or editing in place\n")
+		os.Exit(exitError)
+	}
+
+	var matches int64
+	for _, fname := range files {
+		n, err := process(fname, prog)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			failed = true
 		}
-	}
-	return nil
-}
+		matches += n
 
-func processFile(fname string, file *os.File, commands, sep string) error {
-	cmds, err := parseCommands(fname, commands)
-	if err != nil {
-		return err
+		if *optCount {
+			fmt.Printf("%s%d\n", filenamePrefix(fname), n)
+		}
 	}
 
-	ex := NewExecutor(cmds)
-	ex.Sep = sep
-	ex.Go(file)
+	if *optCount && len(files) > 1 {
+		fmt.Printf("total:%d\n", matches)
+	}
 
-	return nil
+	switch {
+	case failed:
+		os.Exit(exitError)
+	case matches == 0:
+		os.Exit(exitNoMatch)
+	}
+	os.Exit(exitMatch)
 }
 
-func processStdin(commands, sep string) error {
-	cmds, err := parseCommands("stdin", commands)
-	if err != nil {
-		return err
+// The exit statuses follow grep's convention.
+const (
+	// exitMatch is the exit status when some range was printed or changed.
+	exitMatch = 0
+	// exitNoMatch is the exit status when nothing was printed or changed.
+	exitNoMatch = 1
+	// exitError is the exit status when there was an error, whether or not there were matches.
+	exitError = 2
+)
+
+// compile compiles the program in the script file given by -f, or else the commands in the
+// first positional argument, and returns it along with the file arguments.
+func compile(args []string) (*srex.Program, []string, error) {
+	if *optFile != "" {
+		script, err := os.ReadFile(*optFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		prog, err := srex.CompileScript(string(script))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", *optFile, err)
+		}
+		return prog, args, nil
 	}
 
-	all, err := ioutil.ReadAll(os.Stdin)
-	if err != nil {
-		return err
+	if len(args) < 1 {
+		return nil, nil, fmt.Errorf("The commands must be specified")
 	}
-	buf := bytes.NewReader(all)
+	commands, files := splitArgs(args)
+	prog, err := srex.Compile(commands)
+	return prog, files, err
+}
 
-	ex := NewExecutor(cmds)
-	ex.Sep = sep
-	ex.Go(buf)
+// splitArgs splits the positional arguments into the commands and the file arguments. For
+// compatibility with earlier versions, which took the file before the commands, two arguments
+// are swapped if the first names an existing file and the second doesn't.
+func splitArgs(args []string) (commands string, files []string) {
+	if len(args) == 2 && fileExists(args[0]) && !fileExists(args[1]) {
+		return args[1], args[:1]
+	}
+	return args[0], args[1:]
+}
 
-	return nil
+func fileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
 }
 
-func lengthOfReaderAt(r io.ReaderAt) (int64, error) {
-	if s, ok := r.(io.Seeker); ok {
-		return s.Seek(0, io.SeekEnd)
-	}
+// showFilenames is true if each match should be prefixed by the name of the file it is in.
+var showFilenames bool
 
-	// Use the readerAtSize function to determine size. First port it.
-	return 0, fmt.Errorf("Can't determine length of ReaderAt since it is not a Seeker")
+// displayName is the name used for the file `fname` in the output.
+func displayName(fname string) string {
+	if fname == stdinName {
+		return "stdin"
+	}
+	return fname
 }
 
-type Range struct {
-	Start, End int64
+// filenamePrefix is the prefix printed before each match in the file `fname`.
+func filenamePrefix(fname string) string {
+	if showFilenames {
+		return displayName(fname) + ":"
+	}
+	return ""
 }
 
-var EmptyRange = Range{}
+// newSink returns the sink that the output for the file `fname` is written to.
+func newSink(fname string, out io.Writer) srex.Sink {
+	if *optCount {
+		return countOnlySink{newOutputSink(fname, out)}
+	}
+	return newOutputSink(fname, out)
+}
 
-func emptyRange(start, end int64) bool {
-	return end-start <= 0
+// countOnlySink is the sink used with -c. Only the counts of the # commands are output, since
+// the number of ranges output is printed instead of the ranges.
+type countOnlySink struct {
+	srex.Sink
 }
 
-func (r Range) IsEmpty() bool {
-	return r.Start == r.End && r.Start == 0
+func (countOnlySink) Print(data io.ReaderAt, m srex.Match) error {
+	return nil
 }
 
-func (r Range) SectionReader(input io.ReaderAt) *io.SectionReader {
-	return io.NewSectionReader(input, int64(r.Start), int64(r.End-r.Start))
+func (countOnlySink) PrintLocation(loc srex.Location) error {
+	return nil
 }
 
-var completeRange = Range{-1, -1}
-
-func parseCommands(fname string, commands string) (result []Command, err error) {
-	result = []Command{}
-	for _, s := range tokenizeCommands(commands) {
-		cmdLabel := []rune(s)[0]
-		switch cmdLabel {
-		case 'x', 'y', 'g', 'v', 'z':
-			if len(s) < 3 {
-				err = fmt.Errorf("Command '%s' is malformatted", s)
-				return
-			}
-			var re *regexp.Regexp
-			re, err = parseCommandRegexp(s)
-			if err != nil {
-				return
-			}
-			cmd := NewRegexpCommand(cmdLabel, re)
-			result = append(result, cmd)
-		case 'p':
-			cmd := NewPrintCommand(os.Stdout, *optSep)
-			result = append(result, cmd)
-		case '=':
-			cmd := NewPrintLineCommand(fname, os.Stdout)
-			result = append(result, cmd)
-		case 'n':
-			p, err := extractArraylikeCommandParameter(s)
-			cmd, err := NewNCommand(p)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, cmd)
-		default:
-			err = fmt.Errorf("Unknown command '%c'", cmdLabel)
-			return
-		}
-	}
-	return
+func (countOnlySink) PrintText(m srex.Match, text []byte) error {
+	return nil
 }
 
-func tokenizeCommands(commands string) []string {
-	var t tokenizer
-	return t.tokenize(commands)
+func (s countOnlySink) PrintCount(n int64) error {
+	if p, ok := s.Sink.(srex.CountPrinter); ok {
+		return p.PrintCount(n)
+	}
+	return nil
 }
 
-type tokenizer struct {
-	runes []rune
-	cmd   bytes.Buffer
-	cmds  []string
+func (countOnlySink) PrintAggregate(a srex.Aggregate) error {
+	return nil
 }
 
-func (t *tokenizer) tokenize(commands string) []string {
-	t.runes = []rune(commands)
-	t.innerTokenize()
-	return t.cmds
+func (countOnlySink) Edited(data io.ReaderAt, length int64, edits *srex.EditLog) error {
+	return nil
 }
 
-func (t *tokenizer) innerTokenize() {
-	const (
-		Default = iota
-		WaitingForTerminator
-		EscapeNext
-	)
-
-	var state = Default
-	var terminator rune
-	for _, r := range t.runes {
-		switch state {
-		case Default:
-			if unicode.IsSpace(r) {
-				continue
-			}
-			t.addRuneToCurrentCommand(r)
-			switch r {
-			case '/':
-				state = WaitingForTerminator
-				terminator = '/'
-			case '[':
-				state = WaitingForTerminator
-				terminator = ']'
-			}
-		case WaitingForTerminator:
-			t.addRuneToCurrentCommand(r)
-			switch r {
-			case terminator:
-				state = Default
-				t.addCommand()
-			case '\\':
-				state = EscapeNext
-			}
-		case EscapeNext:
-			t.addRuneToCurrentCommand(r)
-			state = WaitingForTerminator
-		}
+func newOutputSink(fname string, out io.Writer) srex.Sink {
+	if *optJSON {
+		return &srex.JSONSink{Out: out, Name: displayName(fname)}
 	}
 
-	if t.cmd.Len() != 0 {
-		t.addCommand()
+	name := displayName(fname)
+	if *optNoFilename {
+		name = ""
 	}
-}
 
-func (t *tokenizer) addRuneToCurrentCommand(r rune) {
-	t.cmd.WriteRune(r)
+	sink := srex.WriterSink{
+		Out:         out,
+		Sep:         *optSep,
+		Prefix:      filenamePrefix(fname),
+		Name:        name,
+		File:        displayName(fname),
+		ChangedOnly: *optChanged,
+		Framing:     framing(),
+		Highlight:   optColor.enabled(),
+	}
+	if template != nil {
+		return &srex.TemplateSink{WriterSink: sink, Template: template}
+	}
+	return &sink
 }
 
-func (t *tokenizer) addCommand() {
-	t.cmds = append(t.cmds, t.cmd.String())
-	t.cmd.Reset()
-}
+// template is the template given by -o, if there is one.
+var template *srex.Template
 
-func parseCommandRegexp(command string) (re *regexp.Regexp, err error) {
-	reText, err := extractRegexpCommandParameter(command)
+// parseTemplate parses the template given by -o, in which the escapes of a Go string such as \t
+// may be used.
+func parseTemplate(s string) (*srex.Template, error) {
+	s, err := srex.Unescape(s)
 	if err != nil {
-		return
+		return nil, err
 	}
-	re, err = regexp.Compile(reText)
-	return
-}
-
-func extractRegexpCommandParameter(command string) (param string, err error) {
-	return extractCommandParameter(command, '/', '/')
+	return srex.ParseTemplate(s)
 }
 
-func extractArraylikeCommandParameter(command string) (param string, err error) {
-	return extractCommandParameter(command, '[', ']')
+// framing returns the framing of the printed matches chosen by the options.
+func framing() srex.Framing {
+	switch {
+	case *optNetstring:
+		return srex.NetstringFraming
+	case *optNull:
+		return srex.NullFraming
+	}
+	return srex.NoFraming
 }
 
-func extractCommandParameter(command string, lmark, rmark rune) (param string, err error) {
-	// First char of the command is the command label, then it must be /.../
-	if len(command) < 3 {
-		err = fmt.Errorf("Command '%s' is malformatted", command)
-		return
+// process runs the program on the file `fname`, and returns the number of matches.
+func process(fname string, prog *srex.Program) (int64, error) {
+	if fname == stdinName {
+		if optInPlace.enabled {
+			return 0, fmt.Errorf("A file must be specified to edit in place")
+		}
+		return processStdin(prog)
 	}
 
-	if command[1] != byte(lmark) {
-		err = fmt.Errorf("Command '%c' must be followed by a forward slash (the complete command is: '%s')",
-			command[0], command)
-		return
+	file, err := os.Open(fname)
+	if err != nil {
+		return 0, err
 	}
+	defer file.Close()
 
-	if command[len(command)-1] != byte(rmark) {
-		err = fmt.Errorf("Command '%c' must be terminated by a forward slash (the complete command is: '%s')",
-			command[0], command)
-		return
+	if optInPlace.enabled {
+		var matches int64
+		err = editInPlace(fname, optInPlace.suffix, func(out io.Writer) (err error) {
+			matches, err = prog.Exec(context.Background(), file, newSink(fname, out))
+			return
+		})
+		return matches, err
 	}
-
-	param = command[2 : len(command)-1]
-	return
+	if *optFollow {
+		return processFollow(fname, file, prog)
+	}
+	return prog.Exec(context.Background(), file, newSink(fname, os.Stdout))
 }
 
-func readRange(data io.ReaderAt, start, end int64) (buf []byte, err error) {
-	if end < start {
-		panic(fmt.Sprintf("readRange: can't read range %d-%d", start, end))
-	}
-	buf = make([]byte, end-start)
-	_, err = data.ReadAt(buf, start)
-	if err == io.EOF {
-		err = nil
+// processFollow processes the file `fname` as a stream that doesn't end, printing the matches as
+// the file grows.
+func processFollow(fname string, file *os.File, prog *srex.Program) (int64, error) {
+	if err := prog.CheckStreamable(); err != nil {
+		return 0, err
 	}
 
-	return
+	rdr := newFollowReader(fname, file)
+	defer rdr.Close()
+	buf := srex.NewStream(rdr)
+	defer buf.Close()
+
+	return prog.Exec(context.Background(), buf, newSink(fname, os.Stdout))
 }
 
-/*
-// Determine the size of a ReaderAt using a binary search. Given that file
-// offsets are no larger than int64, there is an upper limit of 64 iterations
-// before the EOF is found.
-func readerAtSize(rd io.ReaderAt) (pos int64, err error) {
-	defer errs.Recover(&err)
-
-	// Function to check if the given position is at EOF
-	buf := make([]byte, 2)
-	checkEOF := func(pos int64) int {
-		if pos > 0 {
-			cnt, err := rd.ReadAt(buf[:2], pos-1)
-			errs.Panic(errs.Ignore(err, io.EOF))
-			return 1 - cnt // RetVal[Cnt] = {0: +1, 1: 0, 2: -1}
-		} else { // Special case where position is zero
-			cnt, err := rd.ReadAt(buf[:1], pos-0)
-			errs.Panic(errs.Ignore(err, io.EOF))
-			return 0 - cnt // RetVal[Cnt] = {0: 0, 1: -1}
-		}
-	}
+func processStdin(prog *srex.Program) (int64, error) {
+	buf := srex.NewStream(os.Stdin)
+	defer buf.Close()
 
-	// Obtain the size via binary search O(log n) => 64 iterations
-	posMin, posMax := int64(0), int64(math.MaxInt64)
-	for posMax >= posMin {
-		pos = (posMax + posMin) / 2
-		switch checkEOF(pos) {
-		case -1: // Below EOF
-			posMin = pos + 1
-		case 0: // At EOF
-			return pos, nil
-		case +1: // Above EOF
-			posMax = pos - 1
-		}
-	}
-	panic(errs.New("EOF is in a transient state"))
-}
-*/
-
-func replaceEscapes(s string) (string, error) {
-	var b bytes.Buffer
-
-	esc := false
-	for _, r := range []rune(s) {
-		if !esc {
-			if r == '\\' {
-				esc = true
-			} else {
-				b.WriteRune(r)
-			}
-		} else {
-			switch r {
-			case 'n':
-				b.WriteRune('\n')
-			case '\\':
-				b.WriteRune('\\')
-			default:
-				return "", fmt.Errorf("Invalid escape character")
-			}
-			esc = false
-		}
-	}
-	return b.String(), nil
+	return prog.Exec(context.Background(), buf, newSink(stdinName, os.Stdout))
 }
@@ -1,10 +1,125 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/ogier/pflag"
 )
 
 var (
-	optDebug = pflag.BoolP("debug", "d", false, "Print debug info")
-	optSep   = pflag.StringP("separator", "s", "", "String to print between matches")
+	optDebug   = pflag.BoolP("debug", "d", false, "Print debug info")
+	optSep     = pflag.StringP("separator", "s", "", "String to print between matches")
+	optFile    = pflag.StringP("file", "f", "", "Read the commands from the script file")
+	optChanged = pflag.Bool("changed", false, "When editing, only print the changed ranges")
+	optInPlace inPlaceValue
+	optColor   = colorValue{mode: "never"}
+
+	optRecursive    = pflag.BoolP("recursive", "r", false, "Process the files in directories, recursively")
+	optWithFilename = pflag.BoolP("with-filename", "H", false, "Print the file name before each match")
+	optNoFilename   = pflag.BoolP("no-filename", "h", false, "Never print the file name before each match")
+	optFollow       = pflag.BoolP("follow", "F", false, "Keep reading the file as it grows, like tail -F")
+	optJSON         = pflag.Bool("json", false, "Print each match as a JSON object on its own line")
+	optNull         = pflag.BoolP("null", "z", false, "Lines end with NUL bytes instead of newlines, and each match is followed by a NUL byte")
+	optNetstring    = pflag.Bool("netstring", false, "Print each match as a netstring")
+	optTemplate     = pflag.StringP("template", "o", "", "Print each match through the template, such as '{file}:{line} {name}'")
+	optRunes        = pflag.Bool("runes", false, "Count the offsets and columns printed by =# and =+ in runes instead of bytes")
+
+	optCount   = pflag.BoolP("count", "c", false, "Print the number of matches instead of the matches")
+	optAfter   = pflag.IntP("after-context", "A", 0, "Print N records after each record with a match")
+	optBefore  = pflag.IntP("before-context", "B", 0, "Print N records before each record with a match")
+	optContext = pflag.IntP("context", "C", 0, "Print N records before and after each record with a match")
 )
+
+func init() {
+	pflag.VarP(&optInPlace, "in-place", "i", "Edit the file in place, keeping a backup with the suffix if one is given")
+	pflag.Var(&optColor, "color", "Highlight the matches: auto, always or never")
+}
+
+// flagGiven returns true if the option named `name` was given on the command line, even if it
+// was given its default value.
+func flagGiven(name string) (given bool) {
+	pflag.Visit(func(f *pflag.Flag) {
+		given = given || f.Name == name
+	})
+	return
+}
+
+// inPlaceValue is the value of the --in-place option. Like sed's -i it takes an
+// optional backup suffix, so it is a boolean flag that may also be given a value.
+type inPlaceValue struct {
+	enabled bool
+	suffix  string
+}
+
+func (v *inPlaceValue) String() string {
+	return v.suffix
+}
+
+func (v *inPlaceValue) Set(s string) error {
+	v.enabled = true
+	if s != "true" {
+		v.suffix = s
+	}
+	return nil
+}
+
+func (v *inPlaceValue) IsBoolFlag() bool {
+	return true
+}
+
+// colorValue is the value of the --color option. Like grep's --color the value is optional,
+// and --color alone means auto.
+type colorValue struct {
+	mode string
+}
+
+func (v *colorValue) String() string {
+	return v.mode
+}
+
+func (v *colorValue) Set(s string) error {
+	switch s {
+	case "true":
+		s = "auto"
+	case "auto", "always", "never":
+	default:
+		return fmt.Errorf("Invalid color mode '%s': it must be auto, always or never", s)
+	}
+	v.mode = s
+	return nil
+}
+
+func (v *colorValue) IsBoolFlag() bool {
+	return true
+}
+
+// enabled returns true if the output should be highlighted. In auto mode that's when stdout
+// is a terminal that supports it.
+func (v *colorValue) enabled() bool {
+	switch v.mode {
+	case "always":
+		return true
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+	}
+	return false
+}
+
+// expandInPlaceArgs rewrites -i<suffix> in args to --in-place=<suffix>, since
+// pflag would otherwise treat the suffix as more short options.
+func expandInPlaceArgs(args []string) []string {
+	result := make([]string, 0, len(args))
+	for i, a := range args {
+		if a == "--" {
+			return append(result, args[i:]...)
+		}
+		if strings.HasPrefix(a, "-i") && len(a) > 2 {
+			a = "--in-place=" + a[2:]
+		}
+		result = append(result, a)
+	}
+	return result
+}
@@ -0,0 +1,442 @@
+package srex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// addressChars are the characters that may appear in a sam address outside of a regular expression.
+const addressChars = "0123456789#$.+-,;"
+
+// isAddressStart returns true if `r` can begin a sam address.
+func isAddressStart(r rune) bool {
+	return strings.ContainsRune(addressChars, r) || r == '/' || r == '?'
+}
+
+// addressCommand selects the part of the range identified by a sam address, such as
+// 10,20 or /BEGIN/,/END/. Dot, the current selection, starts out as the empty range at the
+// start of the range, and line numbers are counted from the start of the range.
+type addressCommand struct {
+	addr address
+	// nullLines makes the lines end with NUL bytes instead of newlines.
+	nullLines bool
+}
+
+// newAddressCommand returns a new addressCommand for the address `s`.
+func newAddressCommand(s string) (*addressCommand, error) {
+	p := addressParser{runes: []rune(s)}
+	a, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &addressCommand{addr: a}, nil
+}
+
+func (c *addressCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	text := addressText{data, in.Start, in.End, lineEnd(c.nullLines)}
+	r, err := c.addr.eval(text, Range{in.Start, in.Start})
+	if err != nil {
+		return err
+	}
+
+	dbg("AddressCommand.Do: address is %d-%d\n", r.Start, r.End)
+	match(Match{Range: r, Groups: in.Groups})
+	return nil
+}
+
+// addressText is the text that addresses are evaluated in: the part of `data` between `start` and
+// `end`, made of lines that end with `sep`.
+type addressText struct {
+	data       io.ReaderAt
+	start, end int64
+	sep        byte
+}
+
+// address is a parsed sam address. eval returns the range the address refers to given the
+// current selection `dot`.
+type address interface {
+	eval(t addressText, dot Range) (Range, error)
+}
+
+// charAddress is #n: the empty range after the n'th byte.
+type charAddress int64
+
+// lineAddress is n: the n'th line, including its newline. Line 0 is the empty range at the start.
+type lineAddress int
+
+// endAddress is $: the empty range at the end.
+type endAddress struct{}
+
+// dotAddress is .: the current selection.
+type dotAddress struct{}
+
+// regexpAddress is /re/, which searches forward for the regexp, or ?re?, which searches backward.
+// Both searches wrap around.
+type regexpAddress struct {
+	re       *regexp.Regexp
+	backward bool
+}
+
+// relativeAddress is a1+a2 or a1-a2: the address a2 evaluated forward from the end of a1, or
+// backward from the start of a1.
+type relativeAddress struct {
+	a1, a2   address
+	backward bool
+}
+
+// compoundAddress is a1,a2 or a1;a2: the range from the start of a1 to the end of a2. With a
+// semicolon, dot is set to a1 before a2 is evaluated.
+type compoundAddress struct {
+	a1, a2 address
+	semi   bool
+}
+
+func (a charAddress) eval(t addressText, dot Range) (Range, error) {
+	return t.charRange(t.start + int64(a))
+}
+
+func (a lineAddress) eval(t addressText, dot Range) (Range, error) {
+	return t.line(int(a))
+}
+
+func (a endAddress) eval(t addressText, dot Range) (Range, error) {
+	return Range{t.end, t.end}, nil
+}
+
+func (a dotAddress) eval(t addressText, dot Range) (Range, error) {
+	return dot, nil
+}
+
+func (a regexpAddress) eval(t addressText, dot Range) (Range, error) {
+	if a.backward {
+		return t.searchBackward(a.re, dot.Start)
+	}
+	return t.searchForward(a.re, dot.End)
+}
+
+func (a relativeAddress) eval(t addressText, dot Range) (Range, error) {
+	base := dot
+	if a.a1 != nil {
+		var err error
+		base, err = a.a1.eval(t, dot)
+		if err != nil {
+			return base, err
+		}
+	}
+
+	switch a2 := a.a2.(type) {
+	case lineAddress:
+		if a.backward {
+			l, err := t.lineNumber(base.Start)
+			if err != nil {
+				return base, err
+			}
+			return t.line(l - int(a2))
+		}
+		if base.End == t.start {
+			return t.line(int(a2))
+		}
+		l, err := t.lineNumber(base.End - 1)
+		if err != nil {
+			return base, err
+		}
+		return t.line(l + int(a2))
+	case charAddress:
+		if a.backward {
+			return t.charRange(base.Start - int64(a2))
+		}
+		return t.charRange(base.End + int64(a2))
+	case regexpAddress:
+		if a.backward != a2.backward {
+			return t.searchBackward(a2.re, base.Start)
+		}
+		return t.searchForward(a2.re, base.End)
+	default:
+		return a.a2.eval(t, base)
+	}
+}
+
+func (a compoundAddress) eval(t addressText, dot Range) (Range, error) {
+	r1, err := a.a1.eval(t, dot)
+	if err != nil {
+		return r1, err
+	}
+
+	if a.semi {
+		dot = r1
+	}
+
+	r2, err := a.a2.eval(t, dot)
+	if err != nil {
+		return r2, err
+	}
+
+	if r2.End < r1.Start {
+		return r1, fmt.Errorf("Addresses out of order: %d-%d comes before %d-%d", r2.Start, r2.End, r1.Start, r1.End)
+	}
+	return Range{r1.Start, r2.End}, nil
+}
+
+func (t addressText) charRange(off int64) (Range, error) {
+	if off < t.start || off > t.end {
+		return Range{}, fmt.Errorf("Address out of range: character %d", off-t.start)
+	}
+	return Range{off, off}, nil
+}
+
+func (t addressText) reader(start int64) *bufio.Reader {
+	return bufio.NewReader(io.NewSectionReader(t.data, start, t.end-start))
+}
+
+// line returns the range of line number `l`.
+func (t addressText) line(l int) (Range, error) {
+	if l < 0 {
+		return Range{}, fmt.Errorf("Address out of range: line %d", l)
+	}
+	if l == 0 {
+		return Range{t.start, t.start}, nil
+	}
+
+	rdr := t.reader(t.start)
+	r := Range{t.start, t.start}
+	for n := 1; ; n++ {
+		buf, err := rdr.ReadSlice(t.sep)
+		for err == bufio.ErrBufferFull {
+			r.End += int64(len(buf))
+			buf, err = rdr.ReadSlice(t.sep)
+		}
+		r.End += int64(len(buf))
+
+		if n == l {
+			if r.Start == r.End && r.Start == t.end && n > 1 {
+				break
+			}
+			return r, nil
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Range{}, err
+		}
+		r.Start = r.End
+	}
+
+	return Range{}, fmt.Errorf("Address out of range: line %d", l)
+}
+
+// lineNumber returns the number of the line that contains the byte at offset `off`. The text
+// before it is read in chunks so that only a chunk is held at a time.
+func (t addressText) lineNumber(off int64) (int, error) {
+	rdr := io.NewSectionReader(t.data, t.start, off-t.start)
+	buf := make([]byte, 32*1024)
+	n := 1
+	for {
+		l, err := rdr.Read(buf)
+		n += bytes.Count(buf[:l], []byte{t.sep})
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// searchForward finds the first match of `re` at or after `off`, wrapping around to the start if there is none.
+func (t addressText) searchForward(re *regexp.Regexp, off int64) (Range, error) {
+	if loc := re.FindReaderIndex(t.reader(off)); loc != nil {
+		return Range{off + int64(loc[0]), off + int64(loc[1])}, nil
+	}
+	if loc := re.FindReaderIndex(t.reader(t.start)); loc != nil {
+		return Range{t.start + int64(loc[0]), t.start + int64(loc[1])}, nil
+	}
+	return Range{}, fmt.Errorf("No match for /%s/", re)
+}
+
+// searchBackward finds the last match of `re` that ends at or before `off`, wrapping around to the end if there is none.
+func (t addressText) searchBackward(re *regexp.Regexp, off int64) (Range, error) {
+	var last, lastBefore *Range
+	pos := t.start
+	for pos <= t.end {
+		loc := re.FindReaderIndex(t.reader(pos))
+		if loc == nil {
+			break
+		}
+
+		r := Range{pos + int64(loc[0]), pos + int64(loc[1])}
+		if r.End > off && lastBefore != nil {
+			break
+		}
+		last = &r
+		if r.End <= off {
+			lastBefore = &r
+		}
+
+		pos = r.End
+		if r.Start == r.End {
+			pos++
+		}
+	}
+
+	if lastBefore != nil {
+		return *lastBefore, nil
+	}
+	if last != nil {
+		return *last, nil
+	}
+	return Range{}, fmt.Errorf("No match for ?%s?", re)
+}
+
+// addressParser parses sam addresses. The grammar is:
+//
+//	address  := relative [(',' | ';') [address]] | (',' | ';') [address]
+//	relative := [simple] {('+' | '-') [simple]}
+//	simple   := '#' number | number | '/' regexp '/' | '?' regexp '?' | '$' | '.'
+type addressParser struct {
+	runes []rune
+	pos   int
+}
+
+func (p *addressParser) parse() (address, error) {
+	a, err := p.parseAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.runes) {
+		return nil, fmt.Errorf("Invalid address '%s': unexpected '%c'", string(p.runes), p.runes[p.pos])
+	}
+	if a == nil {
+		return nil, fmt.Errorf("Invalid address '%s'", string(p.runes))
+	}
+	return a, nil
+}
+
+func (p *addressParser) parseAddress() (address, error) {
+	a1, err := p.parseRelative()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos >= len(p.runes) || (p.runes[p.pos] != ',' && p.runes[p.pos] != ';') {
+		return a1, nil
+	}
+
+	semi := p.runes[p.pos] == ';'
+	p.pos++
+
+	a2, err := p.parseAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	if a1 == nil {
+		a1 = lineAddress(0)
+	}
+	if a2 == nil {
+		a2 = endAddress{}
+	}
+	return compoundAddress{a1, a2, semi}, nil
+}
+
+func (p *addressParser) parseRelative() (address, error) {
+	a, err := p.parseSimple()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.runes) && (p.runes[p.pos] == '+' || p.runes[p.pos] == '-') {
+		backward := p.runes[p.pos] == '-'
+		p.pos++
+
+		a2, err := p.parseSimple()
+		if err != nil {
+			return nil, err
+		}
+		if a2 == nil {
+			a2 = lineAddress(1)
+		}
+
+		a = relativeAddress{a, a2, backward}
+	}
+	return a, nil
+}
+
+func (p *addressParser) parseSimple() (address, error) {
+	if p.pos >= len(p.runes) {
+		return nil, nil
+	}
+
+	switch r := p.runes[p.pos]; {
+	case r == '#':
+		p.pos++
+		n, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		return charAddress(n), nil
+	case r >= '0' && r <= '9':
+		n, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		return lineAddress(n), nil
+	case r == '/' || r == '?':
+		re, err := p.parseRegexp(r)
+		if err != nil {
+			return nil, err
+		}
+		return regexpAddress{re, r == '?'}, nil
+	case r == '$':
+		p.pos++
+		return endAddress{}, nil
+	case r == '.':
+		p.pos++
+		return dotAddress{}, nil
+	}
+	return nil, nil
+}
+
+func (p *addressParser) parseNumber() (int, error) {
+	start := p.pos
+	for p.pos < len(p.runes) && p.runes[p.pos] >= '0' && p.runes[p.pos] <= '9' {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("Invalid address '%s': expected a number at position %d", string(p.runes), start)
+	}
+	return strconv.Atoi(string(p.runes[start:p.pos]))
+}
+
+func (p *addressParser) parseRegexp(delim rune) (*regexp.Regexp, error) {
+	end, ok := skipDelimited(p.runes, p.pos)
+	if !ok {
+		return nil, fmt.Errorf("Invalid address '%s': regexp is not terminated by '%c'", string(p.runes), delim)
+	}
+
+	text := string(p.runes[p.pos+1 : end-1])
+	p.pos = end
+	return regexp.Compile(text)
+}
+
+// skipDelimited returns the index just past the text delimited by the rune at index `i`,
+// taking backslash escapes into account. If the text is unterminated it returns len(runes) and false.
+func skipDelimited(runes []rune, i int) (int, bool) {
+	delim := runes[i]
+	for i++; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case delim:
+			return i + 1, true
+		}
+	}
+	return len(runes), false
+}
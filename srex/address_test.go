@@ -0,0 +1,184 @@
+package srex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAddressCommand(t *testing.T) {
+	input := "line1\nBEGIN\nline3\nEND\nline5\nBEGIN\nline7\n"
+
+	tests := []struct {
+		name     string
+		addr     string
+		expected string
+		err      bool
+	}{
+		{
+			name:     "line",
+			addr:     "2",
+			expected: "BEGIN\n",
+		},
+		{
+			name:     "lines",
+			addr:     "2,3",
+			expected: "BEGIN\nline3\n",
+		},
+		{
+			name:     "line to end",
+			addr:     "5,",
+			expected: "line5\nBEGIN\nline7\n",
+		},
+		{
+			name:     "start to line",
+			addr:     ",2",
+			expected: "line1\nBEGIN\n",
+		},
+		{
+			name:     "everything",
+			addr:     ",",
+			expected: input,
+		},
+		{
+			name:     "last line",
+			addr:     "$-1",
+			expected: "line7\n",
+		},
+		{
+			name: "line out of range",
+			addr: "9",
+			err:  true,
+		},
+		{
+			name:     "chars",
+			addr:     "#6,#12",
+			expected: "BEGIN\n",
+		},
+		{
+			name: "char out of range",
+			addr: "#100",
+			err:  true,
+		},
+		{
+			name:     "regexps",
+			addr:     "/BEGIN/,/END/",
+			expected: "BEGIN\nline3\nEND",
+		},
+		{
+			name:     "regexp to end",
+			addr:     "/END/+1,$",
+			expected: "line5\nBEGIN\nline7\n",
+		},
+		{
+			name:     "regexp after regexp",
+			addr:     "/END/;/BEGIN/",
+			expected: "END\nline5\nBEGIN",
+		},
+		{
+			name:     "backward regexp",
+			addr:     "$-?BEGIN?",
+			expected: "BEGIN",
+		},
+		{
+			name: "regexp no match",
+			addr: "/MIDDLE/",
+			err:  true,
+		},
+		{
+			name:     "dot plus lines",
+			addr:     ".+3",
+			expected: "line3\n",
+		},
+		{
+			name:     "regexp plus lines",
+			addr:     "/BEGIN/+2",
+			expected: "END\n",
+		},
+		{
+			name:     "regexp minus line",
+			addr:     "/END/-",
+			expected: "line3\n",
+		},
+		{
+			name: "out of order",
+			addr: "4,2",
+			err:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newAddressCommand(tc.addr)
+			if err != nil {
+				t.Fatalf("Error parsing address '%s': %v", tc.addr, err)
+			}
+
+			rdr := strings.NewReader(input)
+			var result string
+			err = c.Do(rdr, Match{Range: Range{0, int64(len(input))}}, func(m Match) {
+				result = input[m.Start:m.End]
+			})
+
+			if tc.err {
+				if err == nil {
+					t.Fatalf("Expected an error but got '%s'", result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error evaluating address '%s': %v", tc.addr, err)
+			}
+
+			if result != tc.expected {
+				t.Fatalf("Expected '%s' but got '%s'", tc.expected, result)
+			}
+		})
+	}
+}
+
+// TestAddressLongInput tests line addresses relative to text that spans many of the chunks that
+// the lines are counted in.
+func TestAddressLongInput(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(&b, "line%d\n", i)
+	}
+	input := b.String()
+
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{addr: "$-2", expected: "line19999\n"},
+		{addr: "/line15000\n/-1", expected: "line14999\n"},
+		{addr: "/line15000\n/+2", expected: "line15002\n"},
+	}
+
+	for _, tc := range tests {
+		c, err := newAddressCommand(tc.addr)
+		if err != nil {
+			t.Fatalf("Error parsing address '%s': %v", tc.addr, err)
+		}
+
+		var result string
+		err = c.Do(strings.NewReader(input), Match{Range: Range{0, int64(len(input))}}, func(m Match) {
+			result = input[m.Start:m.End]
+		})
+		if err != nil {
+			t.Fatalf("Error evaluating address '%s': %v", tc.addr, err)
+		}
+		if result != tc.expected {
+			t.Fatalf("Expected '%s' for '%s' but got '%s'", tc.expected, tc.addr, result)
+		}
+	}
+}
+
+func TestParseAddressErrors(t *testing.T) {
+	for _, a := range []string{"/BEGIN", "#", "1x", "+/a("} {
+		if _, err := newAddressCommand(a); err == nil {
+			t.Fatalf("Expected an error parsing address '%s'", a)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package srex
+
+import (
+	"io"
+	"strings"
+)
+
+// aggregateCommand is the k command, which counts the ranges by key. The key of a range is the
+// text captured by the regexp, as the w command captures it, and the ranges the regexp doesn't
+// match are left out. Once all of the input has been read the command outputs an Aggregate for
+// each key to its Sink, in the order the keys were first seen. With k:field/re/ the aggregates
+// also have the least, greatest and sum of the numbers in the field of the ranges, where the
+// field is the name or number of a group as for g:field/re/.
+type aggregateCommand struct {
+	regexpCommand
+	sink  Sink
+	field string
+
+	aggregates []*Aggregate
+	byKey      map[string]*Aggregate
+	count      int64
+}
+
+func (c *aggregateCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("AggregateCommand.Do for %d-%d\n", in.Start, in.End)
+	key, groups, err := c.capture(data, in)
+	if err != nil || groups == nil {
+		return err
+	}
+
+	a := c.byKey[string(key)]
+	if a == nil {
+		if c.byKey == nil {
+			c.byKey = map[string]*Aggregate{}
+		}
+		a = &Aggregate{Key: string(key), Field: c.field}
+		c.byKey[a.Key] = a
+		c.aggregates = append(c.aggregates, a)
+	}
+	a.Count++
+	c.count++
+
+	if c.field == "" {
+		return nil
+	}
+	r, ok := groups.Field(c.field)
+	if !ok {
+		return nil
+	}
+	buf, err := readRange(data, r.Start, r.End)
+	if err != nil {
+		return err
+	}
+	if n, ok := parseNumber(strings.TrimSpace(string(buf))); ok {
+		a.add(n)
+	}
+	return nil
+}
+
+func (c *aggregateCommand) Done() error {
+	for _, a := range c.aggregates {
+		if err := printAggregate(c.sink, *a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the number of ranges that were counted.
+func (c *aggregateCommand) Count() int64 {
+	return c.count
+}
+
+// Aggregate is the summary of the ranges with one key output by the k command.
+type Aggregate struct {
+	Key string
+	// Count is the number of ranges with the key.
+	Count int64
+	// Field is the field whose numbers are aggregated, or "" if there is none. Values is the
+	// number of the ranges with a number in the field, and Min, Max and Sum are the least,
+	// greatest and sum of those numbers.
+	Field         string
+	Values        int64
+	Min, Max, Sum float64
+}
+
+func (a *Aggregate) add(n float64) {
+	if a.Values == 0 || n < a.Min {
+		a.Min = n
+	}
+	if a.Values == 0 || n > a.Max {
+		a.Max = n
+	}
+	a.Sum += n
+	a.Values++
+}
@@ -0,0 +1,60 @@
+package srex
+
+import (
+	"context"
+	"io"
+)
+
+// cancelableReaderAt is an io.ReaderAt whose reads fail once its context is canceled. The
+// executor gives it to the commands so that they stop reading the input when the pipeline is stopped.
+type cancelableReaderAt struct {
+	ctx context.Context
+	io.ReaderAt
+}
+
+// cancelableStream is a cancelableReaderAt for a stream.
+type cancelableStream struct {
+	cancelableReaderAt
+	s streamInput
+}
+
+// newCancelableInput wraps `input` so that reading it fails once `ctx` is canceled.
+func newCancelableInput(ctx context.Context, input io.ReaderAt) io.ReaderAt {
+	r := cancelableReaderAt{ctx, input}
+	if s, ok := input.(streamInput); ok {
+		return cancelableStream{r, s}
+	}
+	return r
+}
+
+func (r cancelableReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func (r cancelableStream) Size() (int64, error) {
+	return r.s.Size()
+}
+
+func (r cancelableStream) release(off int64) {
+	r.s.release(off)
+}
+
+func (r cancelableStream) sectionReader(start, end int64) io.Reader {
+	return cancelableReader{r.ctx, r.s.sectionReader(start, end)}
+}
+
+// cancelableReader is an io.Reader whose reads fail once its context is canceled.
+type cancelableReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r cancelableReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
@@ -0,0 +1,796 @@
+package srex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// command represents a single stage in the pipeline of commands. It processes
+// the range `in` of `data` and if it finds a match calls `match` with the range of
+// the match.
+type command interface {
+	Do(data io.ReaderAt, in Match, match func(m Match)) error
+}
+
+type doner interface {
+	Done() error
+}
+
+// counter is implemented by the terminal commands. Count returns the number of ranges the
+// command has output, or for an editing command, the number of ranges it has changed.
+type counter interface {
+	Count() int64
+}
+
+type regexpCommand struct {
+	regexp       *regexp.Regexp
+	data         io.ReaderAt
+	rdr          *bufio.Reader
+	_offset, end int64
+	// readErr is the first error, other than io.EOF, from reading the input. The regexp package
+	// treats an error as the end of the input, so it is checked once the regexp is done.
+	readErr error
+}
+
+// newRegexpCommand returns a new command that uses the specified Regexp.
+// The `label` chooses which command to build; i.e. 'x' creates an xCommand.
+func newRegexpCommand(label rune, re *regexp.Regexp) (command, error) {
+	switch label {
+	case 'x':
+		return &xCommand{regexpCommand{regexp: re}}, nil
+	case 'g':
+		return &gCommand{regexpCommand: regexpCommand{regexp: re}}, nil
+	case 'y':
+		return &yCommand{regexpCommand{regexp: re}}, nil
+	case 'v':
+		return &vCommand{regexpCommand: regexpCommand{regexp: re}}, nil
+	case 'z':
+		return &zCommand{regexpCommand{regexp: re}, -1}, nil
+	default:
+		return nil, fmt.Errorf("Unknown command '%c'", label)
+	}
+}
+
+func (r *regexpCommand) reader(data io.ReaderAt, start, end int64) io.RuneReader {
+	r.data = data
+	r.readErr = nil
+	r.rdr = bufio.NewReader(r.sectionReader(start, end))
+	r._offset = start
+	r.end = end
+	return r.rdr
+}
+
+func (r *regexpCommand) sectionReader(start, end int64) io.Reader {
+	return errorRecorder{newSectionReader(r.data, start, end), &r.readErr}
+}
+
+func (r *regexpCommand) offset() int64 {
+	return r._offset
+}
+
+func (r *regexpCommand) updateOffset(o int64) {
+	r._offset = o
+	r.rdr.Reset(r.sectionReader(o, r.end))
+}
+
+// errorRecorder is a reader that records the first error other than io.EOF returned by `r` in `err`.
+type errorRecorder struct {
+	r   io.Reader
+	err *error
+}
+
+func (e errorRecorder) Read(p []byte) (n int, err error) {
+	n, err = e.r.Read(p)
+	if err != nil && err != io.EOF && *e.err == nil {
+		*e.err = err
+	}
+	return
+}
+
+// xCommand is like the sam editor's x command: loop over matches of this regexp
+type xCommand struct {
+	regexpCommand
+}
+
+func (c xCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	rdr := c.reader(data, start, end)
+	dbg("XCommand.Do: section reader from %d len %d\n", start, end-start)
+
+	for {
+		locs := c.regexpCommand.regexp.FindReaderSubmatchIndex(rdr)
+		if c.readErr != nil {
+			return c.readErr
+		}
+		if locs == nil {
+			break
+		}
+
+		dbg("XCommand.Do: match at %d-%d\n", locs[0], locs[1])
+		r := Range{c.offset() + int64(locs[0]), c.offset() + int64(locs[1])}
+		match(Match{Range: r, Groups: newGroups(c.regexp, locs, c.offset(), in.Groups)})
+
+		delta := int64(locs[1])
+		if delta == 0 {
+			dbg("XCommand.Do: exiting because match did not make progress forward\n")
+			break
+		}
+		c.updateOffset(c.offset() + delta)
+	}
+
+	return nil
+}
+
+// yCommand is like the sam editor's y command: loop over strings before, between, and after matches of this regexp
+type yCommand struct {
+	regexpCommand
+}
+
+func (c yCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	rdr := c.reader(data, start, end)
+
+	dbg("YCommand.Do: section reader from %d len %d\n", start, end-start)
+
+	for {
+		locs := c.regexpCommand.regexp.FindReaderSubmatchIndex(rdr)
+		if c.readErr != nil {
+			return c.readErr
+		}
+		if locs == nil {
+			break
+		}
+
+		dbg("YCommand.Do: re match at %d-%d\n", locs[0], locs[1])
+		dbg("YCommand.Do: sending match %d-%d\n", c.offset(), c.offset()+int64(locs[0]))
+
+		match(Match{Range: Range{c.offset(), c.offset() + int64(locs[0])}, Groups: in.Groups})
+
+		delta := int64(locs[1])
+		if delta == 0 {
+			dbg("YCommand.Do: exiting because match did not make progress forward\n")
+			break
+		}
+		c.updateOffset(c.offset() + delta)
+	}
+
+	if c.offset() != end {
+		match(Match{Range: Range{c.offset(), end}, Groups: in.Groups})
+	}
+
+	return nil
+}
+
+// yCommand is like the sam editor's y command, but instead of omitting the matching part, it is included
+// as part of the following match.
+type zCommand struct {
+	regexpCommand
+	matchStart int64
+}
+
+func (c zCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	c.matchStart = -1
+
+	rdr := c.reader(data, start, end)
+	dbg("ZCommand.Do: section reader from %d len %d\n", start, end-start)
+
+	for {
+		locs := c.regexpCommand.regexp.FindReaderSubmatchIndex(rdr)
+		if c.readErr != nil {
+			return c.readErr
+		}
+		if locs == nil {
+			break
+		}
+
+		dbg("ZCommand.Do: match starting at %d\n", locs[0])
+		if c.matchStart >= 0 {
+			dbg("ZCommand.Do: match at %d-%d. offset=%d\n", c.matchStart, c.offset()+int64(locs[0]), c.offset())
+			match(Match{Range: Range{c.matchStart, c.offset() + int64(locs[0])}, Groups: in.Groups})
+			c.matchStart = int64(locs[0])
+		}
+		c.matchStart = c.offset() + int64(locs[0])
+
+		delta := int64(locs[1])
+		if delta == 0 {
+			dbg("ZCommand.Do: exiting because match did not make progress forward\n")
+			break
+		}
+
+		c.updateOffset(c.offset() + delta)
+	}
+
+	if c.matchStart >= 0 && c.offset() != end {
+		match(Match{Range: Range{c.matchStart, end}, Groups: in.Groups})
+	}
+
+	return nil
+}
+
+// gCommand is like the sam editor's g command: if the regexp matches the range, output the range, otherwise output no range.
+type gCommand struct {
+	regexpCommand
+	// field is the name or number of the group the regexp is matched against instead of the
+	// whole range, for g:field/re/. If the range has no such group no range is output.
+	field string
+}
+
+func (c gCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if c.field != "" {
+		r, ok := in.Groups.Field(c.field)
+		if !ok {
+			return nil
+		}
+		start, end = r.Start, r.End
+	}
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	rdr := c.reader(data, start, end)
+	dbg("GCommand.Do: section reader from %d len %d\n", start, end-start)
+
+	locs := c.regexpCommand.regexp.FindReaderSubmatchIndex(rdr)
+	if c.readErr != nil {
+		return c.readErr
+	}
+
+	if locs != nil {
+		dbg("GCommand.Do: match\n")
+		match(Match{Range: in.Range, Groups: newGroups(c.regexp, locs, start, in.Groups)})
+		return nil
+	}
+
+	return nil
+}
+
+// vCommand is like the sam editor's y command: if the regexp doesn't match the range, output the range, otherwise output no range.
+type vCommand struct {
+	regexpCommand
+	// field is the name or number of the group the regexp is matched against instead of the
+	// whole range, for v:field/re/. If the range has no such group the range is output.
+	field string
+}
+
+func (c vCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if c.field != "" {
+		r, ok := in.Groups.Field(c.field)
+		if !ok {
+			match(in)
+			return nil
+		}
+		start, end = r.Start, r.End
+	}
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	rdr := c.reader(data, start, end)
+	dbg("GCommand.Do: section reader from %d len %d\n", start, end-start)
+
+	matched := c.regexpCommand.regexp.MatchReader(rdr)
+	if c.readErr != nil {
+		return c.readErr
+	}
+
+	if matched {
+		dbg("GCommand.Do: match\n")
+		return nil
+	}
+
+	match(in)
+
+	return nil
+
+}
+
+// substituteCommand is like the sam editor's s command: replace the first match of
+// the regexp in the range with the replacement text, or every match if global is set.
+// The replacement may refer to the whole match using & and to submatches using \1 to \9.
+// Since the regexp is matched against a byte slice, each range is read into memory as a
+// whole, so s is best used on ranges such as lines or records rather than on a whole
+// large file.
+type substituteCommand struct {
+	regexpCommand
+	editCommand
+	repl   []replacementPart
+	global bool
+}
+
+// replacementPart is a piece of the replacement text of a substituteCommand. It is
+// either literal text, or the submatch with index `group` if `literal` is nil.
+type replacementPart struct {
+	literal []byte
+	group   int
+}
+
+// newSubstituteCommand returns a new substituteCommand that replaces matches of `re` with
+// `repl`.
+func newSubstituteCommand(re *regexp.Regexp, repl string, global bool) (*substituteCommand, error) {
+	parts, err := parseReplacement(repl, re.NumSubexp())
+	if err != nil {
+		return nil, err
+	}
+	return &substituteCommand{regexpCommand: regexpCommand{regexp: re}, repl: parts, global: global}, nil
+}
+
+func (c *substituteCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	if emptyRange(start, end) {
+		return nil
+	}
+
+	buf, err := readRange(data, start, end)
+	if err != nil {
+		return err
+	}
+	dbg("SubstituteCommand.Do: range %d-%d\n", start, end)
+
+	// The regexp is run once over the whole range, rather than restarted after each match, so
+	// that ^ and \b only match where they would in the range, and an empty match right after
+	// the previous match is skipped.
+	n := 1
+	if c.global {
+		n = -1
+	}
+
+	var edits []Edit
+	for _, locs := range c.regexpCommand.regexp.FindAllSubmatchIndex(buf, n) {
+		dbg("SubstituteCommand.Do: match at %d-%d\n", locs[0], locs[1])
+		text := c.expand(buf, locs)
+		edits = append(edits, Edit{Range{start + int64(locs[0]), start + int64(locs[1])}, text})
+	}
+
+	if len(edits) > 0 {
+		c.change(Range{start, end}, edits...)
+	}
+
+	return nil
+}
+
+// expand builds the replacement text for the match in `buf` whose submatch indexes are `locs`.
+func (c *substituteCommand) expand(buf []byte, locs []int) []byte {
+	var text []byte
+	for _, p := range c.repl {
+		if p.literal != nil {
+			text = append(text, p.literal...)
+			continue
+		}
+
+		s, e := locs[2*p.group], locs[2*p.group+1]
+		if s < 0 {
+			// The group did not participate in the match
+			continue
+		}
+		text = append(text, buf[s:e]...)
+	}
+	return text
+}
+
+// parseReplacement splits the replacement text of an s command into parts. & stands for
+// the whole match and \1 to \9 for submatches, \& and \/ stand for & and /, and the other
+// escapes are those of Unescape.
+func parseReplacement(repl string, groups int) (parts []replacementPart, err error) {
+	var lit strings.Builder
+
+	addLiteral := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, replacementPart{literal: []byte(lit.String())})
+			lit.Reset()
+		}
+	}
+
+	addGroup := func(g int) {
+		addLiteral()
+		parts = append(parts, replacementPart{group: g})
+	}
+
+	for i := 0; i < len(repl); {
+		c := repl[i]
+		if c == '&' {
+			addGroup(0)
+			i++
+			continue
+		}
+		if c != '\\' {
+			lit.WriteByte(c)
+			i++
+			continue
+		}
+
+		if i+1 < len(repl) {
+			switch r := repl[i+1]; {
+			case r >= '1' && r <= '9':
+				g := int(r - '0')
+				if g > groups {
+					err = fmt.Errorf("Replacement refers to group \\%c but the regexp only has %d groups", r, groups)
+					return
+				}
+				addGroup(g)
+				i += 2
+				continue
+			case r == '&' || r == '/':
+				lit.WriteByte(r)
+				i += 2
+				continue
+			}
+		}
+
+		n, e := unescapeOne(&lit, repl[i:])
+		if e != nil {
+			err = fmt.Errorf("Replacement is malformatted: %v at character %d", e, utf8.RuneCountInString(repl[:i])+1)
+			return
+		}
+		i += n
+	}
+
+	addLiteral()
+	return
+}
+
+// editCommand holds the EditLog for the commands that edit the range they are given.
+type editCommand struct {
+	log     *EditLog
+	changes int64
+}
+
+func (c *editCommand) SetEditLog(l *EditLog) {
+	c.log = l
+}
+
+func (c *editCommand) change(region Range, edits ...Edit) {
+	c.changes++
+	if c.log != nil {
+		c.log.Change(region, edits...)
+	}
+}
+
+func (c *editCommand) Count() int64 {
+	return c.changes
+}
+
+// newTextCommand returns a new editing command that uses the specified text.
+// The `label` chooses which command to build; i.e. 'c' creates a changeCommand.
+func newTextCommand(label rune, text []byte) (command, error) {
+	switch label {
+	case 'c':
+		return &changeCommand{text: text}, nil
+	case 'a':
+		return &appendCommand{text: text}, nil
+	case 'i':
+		return &insertCommand{text: text}, nil
+	default:
+		return nil, fmt.Errorf("Unknown command '%c'", label)
+	}
+}
+
+// changeCommand is like the sam editor's c command: replace the range with the text.
+type changeCommand struct {
+	editCommand
+	text []byte
+}
+
+func (c *changeCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("ChangeCommand.Do for %d-%d\n", in.Start, in.End)
+	c.change(in.Range, Edit{in.Range, c.text})
+	return nil
+}
+
+// appendCommand is like the sam editor's a command: insert the text after the range.
+type appendCommand struct {
+	editCommand
+	text []byte
+}
+
+func (c *appendCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("AppendCommand.Do for %d-%d\n", in.Start, in.End)
+	r := Range{in.End, in.End}
+	c.change(r, Edit{r, c.text})
+	return nil
+}
+
+// insertCommand is like the sam editor's i command: insert the text before the range.
+type insertCommand struct {
+	editCommand
+	text []byte
+}
+
+func (c *insertCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("InsertCommand.Do for %d-%d\n", in.Start, in.End)
+	r := Range{in.Start, in.Start}
+	c.change(r, Edit{r, c.text})
+	return nil
+}
+
+// deleteCommand is like the sam editor's d command: delete the range.
+type deleteCommand struct {
+	editCommand
+}
+
+func (c *deleteCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("DeleteCommand.Do for %d-%d\n", in.Start, in.End)
+	c.change(in.Range, Edit{Range: in.Range})
+	return nil
+}
+
+// blockCommand is like a block of commands in braces in the sam editor: each range is passed
+// to each of the pipelines in the block in turn.
+type blockCommand struct {
+	pipelines [][]command
+	// errs holds the first error of each pipeline.
+	errs []error
+}
+
+// newBlockCommand returns a new blockCommand that runs the specified pipelines.
+func newBlockCommand(pipelines ...[]command) *blockCommand {
+	return &blockCommand{pipelines: pipelines}
+}
+
+func (b *blockCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("BlockCommand.Do for %d-%d\n", in.Start, in.End)
+	b.initErrors()
+	for i, p := range b.pipelines {
+		if err := runPipeline(p, data, in, &b.errs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blockCommand) Done() error {
+	b.initErrors()
+	for i, p := range b.pipelines {
+		if err := finishPipeline(p, &b.errs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blockCommand) initErrors() {
+	if len(b.errs) != len(b.pipelines) {
+		b.errs = make([]error, len(b.pipelines))
+	}
+}
+
+// printCommand is like the sam editor's p command. It outputs each range to its Sink.
+type printCommand struct {
+	sink    Sink
+	printed int64
+}
+
+func (p *printCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("PrintCommand.Do for %d-%d\n", in.Start, in.End)
+	if err := p.sink.Print(data, in); err != nil {
+		return err
+	}
+	p.printed++
+	return nil
+}
+
+func (p *printCommand) Count() int64 {
+	return p.printed
+}
+
+// newPrintCommand returns a new printCommand that writes to `out` and prints the separator `sep` between each match.
+func newPrintCommand(out io.Writer, sep string) *printCommand {
+	return &printCommand{sink: &WriterSink{Out: out, Sep: sep}}
+}
+
+// printLineCommand is like the sam editor's = command. It outputs the line numbers of each range to its Sink,
+// or with =# the offsets, or with =+ the lines and columns.
+type printLineCommand struct {
+	sink    Sink
+	format  LocationFormat
+	printed int64
+	// lines finds the line numbers. The executor shares its index with the command.
+	lines *lineIndex
+}
+
+// newPrintLineCommand returns a new printLineCommand that writes the line numbers to `out`,
+// preceded by `fname` if it isn't empty.
+func newPrintLineCommand(fname string, out io.Writer) *printLineCommand {
+	return &printLineCommand{sink: &WriterSink{Out: out, Name: fname}}
+}
+
+func (p *printLineCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	start, end := in.Start, in.End
+	dbg("PrintLineCommand.Do for %d-%d\n", start, end)
+
+	if p.lines == nil {
+		p.lines = newLineIndex(data, '\n')
+	}
+
+	loc := Location{Range: Range{start, end}, Format: p.format}
+	var err error
+	if loc.Line, loc.Col, err = p.lines.position(start); err != nil {
+		return err
+	}
+	if loc.EndLine, loc.EndCol, err = p.lines.position(end); err != nil {
+		return err
+	}
+	if loc.Start, err = p.lines.offset(start); err != nil {
+		return err
+	}
+	if loc.End, err = p.lines.offset(end); err != nil {
+		return err
+	}
+
+	if err := p.sink.PrintLocation(loc); err != nil {
+		return err
+	}
+	p.printed++
+	return nil
+}
+
+func (p *printLineCommand) setLineIndex(x *lineIndex) {
+	p.lines = x
+}
+
+func (p *printLineCommand) Count() int64 {
+	return p.printed
+}
+
+// formatCommand is the f command. It outputs the text of its Template for each range to its Sink,
+// such as a one line summary of a record.
+type formatCommand struct {
+	sink      Sink
+	template  *Template
+	formatted int64
+	// lines finds the line numbers. The executor shares its index with the command.
+	lines *lineIndex
+}
+
+func (f *formatCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("FormatCommand.Do for %d-%d\n", in.Start, in.End)
+
+	if f.lines == nil {
+		f.lines = newLineIndex(data, '\n')
+	}
+
+	text, err := f.template.execute(data, in, templateInput{file: inputName(f.sink), lines: f.lines, index: f.formatted})
+	if err != nil {
+		return err
+	}
+	if err := printText(f.sink, in, text); err != nil {
+		return err
+	}
+	f.formatted++
+	return nil
+}
+
+func (f *formatCommand) setLineIndex(x *lineIndex) {
+	f.lines = x
+}
+
+func (f *formatCommand) Count() int64 {
+	return f.formatted
+}
+
+// nCommand only allows ranges in the range [first,last] to pass. Ranges
+// are counted starting from 0.
+// Syntax:
+// 	5  		sixth range
+//     5:6 		sixth and seventh ranges
+//	5:		sixth range to last
+//	0:-2		sixth range to second-last
+//     -1		last
+type nCommand struct {
+	// end == -1 means end is the last possible range.
+	// end == -2 means the second last range
+	start, end int
+	ranges     []Match
+	match      func(m Match)
+}
+
+func newNCommand(s string) (*nCommand, error) {
+	cmd := &nCommand{}
+	var err error
+
+	parts := strings.Split(s, ":")
+	cmd.start, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 1 {
+		// a single number
+		cmd.end = cmd.start
+	} else {
+		if len(parts[1]) == 0 {
+			cmd.end = -1
+		} else {
+			cmd.end, err = strconv.Atoi(parts[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cmd, err
+}
+
+func (p *nCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	p.saveRange(in)
+	p.match = match
+	return nil
+}
+
+func (p *nCommand) saveRange(m Match) {
+	if p.ranges == nil {
+		p.ranges = make([]Match, 0, 20)
+	}
+
+	p.ranges = append(p.ranges, m)
+}
+
+func (p *nCommand) Done() error {
+	p.computeActualStart()
+	p.computeActualEnd()
+
+	if p.start > p.end {
+		// Treat this as the empty set
+		return nil
+	}
+
+	if p.start < 0 || p.end > len(p.ranges) {
+		return nil
+	}
+
+	for _, m := range p.ranges[p.start:p.end] {
+		p.match(m)
+	}
+	return nil
+}
+
+func (p *nCommand) computeActualStart() {
+	if p.start < 0 {
+		p.start = len(p.ranges) + p.start
+	}
+}
+
+func (p *nCommand) computeActualEnd() {
+	if p.end >= 0 {
+		p.end += 1
+	} else {
+		p.end = len(p.ranges) + p.end + 1
+	}
+}
+
+// countCommand is the # command. It passes on each range it is given and counts them. The
+// count is output to its Sink once all of the input has been handled.
+type countCommand struct {
+	sink  Sink
+	count int64
+}
+
+func (c *countCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	c.count++
+	match(in)
+	return nil
+}
+
+// Passed returns the number of ranges that have passed through the command.
+func (c *countCommand) Passed() int64 {
+	return c.count
+}
@@ -0,0 +1,202 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compareCommand is the w command, such as w/length:(\d+)/ > 50. It outputs the range if the
+// regexp matches it and the value it captures compares to the command's value as the operator
+// says, and otherwise outputs no range. The captured value is the first group of the regexp, or
+// the whole match if the regexp has no groups.
+//
+// How the values are compared depends on the command's value. If it's a number, such as 50 or
+// -1.5, they are compared as numbers. If it's a timestamp in one of the timeLayouts, such as
+// 09:08:00 or 2023-10-01T09:08:00Z, they are compared as times. Otherwise they are compared
+// lexically, as strings of bytes. A range whose captured value isn't a number or a time when one
+// is needed is never output.
+type compareCommand struct {
+	regexpCommand
+	op    string
+	value comparand
+}
+
+// compareOps are the operators of the w command, and whether each is true for the result of
+// comparing two values: -1 if the first is less than the second, 0 if they're equal, 1 if it's
+// greater.
+var compareOps = map[string]func(c int) bool{
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+}
+
+// newCompareCommand returns a new compareCommand that outputs the ranges for which the value
+// captured by `re` compares to `value` as `op` says. `op` is one of <, <=, >, >=, == and !=.
+func newCompareCommand(re *regexp.Regexp, op, value string) (*compareCommand, error) {
+	if _, ok := compareOps[op]; !ok {
+		return nil, fmt.Errorf("Comparison '%s' is not one of <, <=, >, >=, == and !=", op)
+	}
+	return &compareCommand{regexpCommand: regexpCommand{regexp: re}, op: op, value: parseComparand(value)}, nil
+}
+
+func (c compareCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	dbg("CompareCommand.Do: range %d-%d\n", in.Start, in.End)
+	buf, groups, err := c.capture(data, in)
+	if err != nil || groups == nil {
+		return err
+	}
+
+	cmp, ok := c.value.compare(string(buf))
+	if ok && compareOps[c.op](cmp) {
+		dbg("CompareCommand.Do: match\n")
+		match(Match{Range: in.Range, Groups: groups})
+	}
+	return nil
+}
+
+// capture returns the text captured by the regexp in the range `in`, which is its first group,
+// or the whole match if it has no groups, along with the groups of the range. The groups are nil
+// if the regexp doesn't match, or the first group didn't take part in the match.
+func (r *regexpCommand) capture(data io.ReaderAt, in Match) ([]byte, *Groups, error) {
+	if emptyRange(in.Start, in.End) {
+		return nil, nil, nil
+	}
+
+	rdr := r.reader(data, in.Start, in.End)
+	locs := r.regexp.FindReaderSubmatchIndex(rdr)
+	if r.readErr != nil {
+		return nil, nil, r.readErr
+	}
+	if locs == nil {
+		return nil, nil, nil
+	}
+
+	groups := newGroups(r.regexp, locs, in.Start, in.Groups)
+	g, ok := groups.Group(0)
+	if groups.Len() > 1 {
+		g, ok = groups.Group(1)
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+
+	buf, err := readRange(data, g.Start, g.End)
+	return buf, groups, err
+}
+
+// comparandKind is how the values compared by the w command are compared.
+type comparandKind int
+
+const (
+	lexicalComparand comparandKind = iota
+	numericComparand
+	timeComparand
+)
+
+// comparand is the value a w command compares the captured values to.
+type comparand struct {
+	kind comparandKind
+	text string
+	num  float64
+	// time is the value as a time. If clock is set the value is only a time of day, and only the
+	// time of day of the captured values is compared to it.
+	time  time.Time
+	clock bool
+}
+
+// timeLayouts are the forms of timestamp that the w command compares as times, in the notation
+// of the time package. The captured values may also have fractions of a second.
+var timeLayouts = []struct {
+	layout string
+	clock  bool
+}{
+	{"15:04:05", true},
+	{"15:04", true},
+	{"2006-01-02T15:04:05Z07:00", false},
+	{"2006-01-02T15:04:05", false},
+	{"2006-01-02 15:04:05Z07:00", false},
+	{"2006-01-02 15:04:05", false},
+	{"2006-01-02", false},
+	{"2006/01/02 15:04:05", false},
+	{"02/Jan/2006:15:04:05 -0700", false},
+	{"Jan _2 15:04:05", false},
+}
+
+func parseComparand(s string) comparand {
+	if n, ok := parseNumber(s); ok {
+		return comparand{kind: numericComparand, text: s, num: n}
+	}
+	if t, clock, ok := parseTime(s); ok {
+		return comparand{kind: timeComparand, text: s, time: t, clock: clock}
+	}
+	return comparand{kind: lexicalComparand, text: s}
+}
+
+// parseNumber parses `s` as a decimal number, such as 50, -1.5 or 1e6.
+func parseNumber(s string) (float64, bool) {
+	if s == "" || !strings.ContainsRune("0123456789+-.", rune(s[0])) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+// parseTime parses `s` as a timestamp in one of the timeLayouts. `clock` is set if it's only a
+// time of day.
+func parseTime(s string) (t time.Time, clock, ok bool) {
+	for _, l := range timeLayouts {
+		var err error
+		if t, err = time.Parse(l.layout, s); err == nil {
+			return t, l.clock, true
+		}
+	}
+	return
+}
+
+// compare compares the captured value `text` to the comparand, returning -1, 0 or 1 if it's less
+// than, equal to or greater than the comparand. The result is false if `text` can't be compared
+// to it.
+func (c comparand) compare(text string) (int, bool) {
+	switch c.kind {
+	case numericComparand:
+		n, ok := parseNumber(text)
+		if !ok {
+			return 0, false
+		}
+		return compareValues(n < c.num, n > c.num), true
+	case timeComparand:
+		t, clock, ok := parseTime(text)
+		if !ok || clock && !c.clock {
+			return 0, false
+		}
+		if c.clock {
+			d, v := sinceMidnight(t), sinceMidnight(c.time)
+			return compareValues(d < v, d > v), true
+		}
+		return compareValues(t.Before(c.time), t.After(c.time)), true
+	}
+	return strings.Compare(text, c.text), true
+}
+
+func compareValues(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// sinceMidnight returns the time of day of `t`.
+func sinceMidnight(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
@@ -0,0 +1,169 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+)
+
+// GroupSeparator is implemented by sinks that print a separator between the groups of records
+// printed with context, the way grep prints -- between groups of lines.
+type GroupSeparator interface {
+	SeparateGroups() error
+}
+
+// contextSink is a Sink that adds the records around the records that have output, like grep's
+// -B and -A options. The records are the ranges of the first command, and the executor calls
+// recordDone once the pipeline has handled each of them. Until then the output for the record is
+// held, so that the records before it can be printed first.
+type contextSink struct {
+	Sink
+	before, after int
+
+	// pending is the output for the current record.
+	pending []func() error
+	// prev are the records, up to `before` of them, since the last one that was printed.
+	prev []Range
+	// afterLeft is the number of records still to print after the last record that had output.
+	afterLeft int
+	// index is the number of the current record, and lastPrinted that of the last record that
+	// was printed, or -1.
+	index, lastPrinted int
+}
+
+func newContextSink(sink Sink, before, after int) *contextSink {
+	return &contextSink{Sink: sink, before: before, after: after, lastPrinted: -1}
+}
+
+func (s *contextSink) Print(data io.ReaderAt, m Match) error {
+	s.pending = append(s.pending, func() error {
+		return s.Sink.Print(data, m)
+	})
+	return nil
+}
+
+func (s *contextSink) PrintLocation(loc Location) error {
+	s.pending = append(s.pending, func() error {
+		return s.Sink.PrintLocation(loc)
+	})
+	return nil
+}
+
+func (s *contextSink) PrintText(m Match, text []byte) error {
+	s.pending = append(s.pending, func() error {
+		return printText(s.Sink, m, text)
+	})
+	return nil
+}
+
+// PrintCount prints the count straight away, since the counts are only printed once all of the
+// records have been handled.
+func (s *contextSink) PrintCount(n int64) error {
+	return printCount(s.Sink, n)
+}
+
+func (s *contextSink) PrintAggregate(a Aggregate) error {
+	return printAggregate(s.Sink, a)
+}
+
+// recordDone outputs the record `r` and the context around it, if it had output, or holds on
+// to it in case a record after it does.
+func (s *contextSink) recordDone(data io.ReaderAt, r Range) error {
+	index := s.index
+	s.index++
+
+	if len(s.pending) == 0 {
+		if s.afterLeft > 0 {
+			s.afterLeft--
+			return s.printRecord(data, index, r)
+		}
+		if s.before > 0 {
+			if len(s.prev) == s.before {
+				s.prev = s.prev[1:]
+			}
+			s.prev = append(s.prev, r)
+		}
+		return nil
+	}
+
+	for i, p := range s.prev {
+		if err := s.printRecord(data, index-len(s.prev)+i, p); err != nil {
+			return err
+		}
+	}
+	s.prev = nil
+
+	if err := s.separate(index); err != nil {
+		return err
+	}
+	for _, fn := range s.pending {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	s.lastPrinted = index
+	s.afterLeft = s.after
+	return nil
+}
+
+func (s *contextSink) printRecord(data io.ReaderAt, index int, r Range) error {
+	if err := s.separate(index); err != nil {
+		return err
+	}
+	s.lastPrinted = index
+	return s.Sink.Print(data, Match{Range: r})
+}
+
+// separate prints the group separator before the record numbered `index` if it doesn't follow
+// the last record that was printed.
+func (s *contextSink) separate(index int) error {
+	if s.lastPrinted < 0 || index == s.lastPrinted+1 {
+		return nil
+	}
+	if sep, ok := s.Sink.(GroupSeparator); ok {
+		return sep.SeparateGroups()
+	}
+	return nil
+}
+
+// keepFrom returns the offset of the start of the input that is still needed for the records
+// held before the next record, or `off` if there are none.
+func (s *contextSink) keepFrom(off int64) int64 {
+	if len(s.prev) > 0 {
+		return s.prev[0].Start
+	}
+	return off
+}
+
+// checkContext returns an error if the context of the records can't be printed for the
+// commands: the first command must divide the input into records, and the output for a record
+// must come out as the record is handled.
+func checkContext(commands []command) error {
+	if len(commands) == 0 || !streamsInput(commands[0]) {
+		return fmt.Errorf("The records for context are the ranges of the first command, so it must be x, y or z")
+	}
+
+	err := commandsAllowContext(commands)
+	walkBlocks(commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			if err == nil {
+				err = commandsAllowContext(p)
+			}
+		}
+	})
+	return err
+}
+
+func commandsAllowContext(commands []command) error {
+	for _, c := range commands {
+		switch c.(type) {
+		case *blockCommand:
+			continue
+		case editor:
+			return fmt.Errorf("Context can't be printed for editing commands")
+		case doner:
+			return fmt.Errorf("Context can't be printed for commands that hold on to ranges until the end of the input, such as n, o, r and k")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package srex
+
+import (
+	"fmt"
+	"os"
+)
+
+// dbg prints debug information to stderr when the package is built with the srexdebug tag.
+func dbg(msg string, args ...interface{}) {
+	if debug {
+		fmt.Fprintf(os.Stderr, msg, args...)
+	}
+}
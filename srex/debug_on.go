@@ -0,0 +1,5 @@
+//go:build srexdebug
+
+package srex
+
+const debug = true
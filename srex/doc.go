@@ -0,0 +1,20 @@
+// Package srex runs structural regular expression programs, like those of the sam editor, on
+// text. A program is a pipeline of commands such as `x/pattern/ g/pattern/ p`: each command
+// takes the ranges of the input selected by the one before it and selects, filters, prints or
+// edits them. For example, this prints the multi-line records of a log that mention an error:
+//
+//	prog, err := srex.Compile(`x/\d+\) Event:.*\n( +.*\n)*/ g/ERROR/`)
+//	if err != nil {
+//		return err
+//	}
+//	err = prog.Run(file, &srex.WriterSink{Out: os.Stdout})
+//
+// The output of a Program goes to a Sink. WriterSink prints it the way the srex command does,
+// TemplateSink formats each range with a Template, and JSONSink prints JSON objects. Other sinks
+// need only implement the methods of Sink, and the optional interfaces such as TextPrinter for
+// the output of the commands they support.
+//
+// A Program reads its input with io.ReaderAt, so that it can go back over the ranges it has
+// selected. Input that can only be read once, such as stdin or a file that is still being
+// written, can be wrapped in a Stream.
+package srex
@@ -0,0 +1,137 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Edit replaces the text in Range with Text. An empty Range is an insertion.
+type Edit struct {
+	Range
+	Text []byte
+}
+
+// change is a region of the input along with the edits made inside it.
+type change struct {
+	region Range
+	edits  []Edit
+}
+
+// EditLog collects the edits made by the editing commands while a Program
+// runs. Like in sam, the input itself is never modified while the commands run;
+// instead the edits are applied in one pass once all the commands are done.
+type EditLog struct {
+	mu      sync.Mutex
+	changes []change
+}
+
+// editor is implemented by commands that modify the input rather than print it.
+// The executor gives each editor the EditLog it should record its edits in.
+type editor interface {
+	SetEditLog(l *EditLog)
+}
+
+// Change records that the region of the input `region` was changed by `edits`. The
+// edits must lie within the region.
+func (l *EditLog) Change(region Range, edits ...Edit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.changes = append(l.changes, change{region, edits})
+}
+
+// Apply writes the input, having length `length`, to `out` with all the edits applied.
+// If `changedOnly` is true then only the changed regions are written, separated by `sep`.
+func (l *EditLog) Apply(input io.ReaderAt, length int64, out io.Writer, changedOnly bool, sep string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sort.SliceStable(l.changes, func(i, j int) bool {
+		return l.changes[i].region.Start < l.changes[j].region.Start
+	})
+
+	edits := l.sortedEdits()
+	if err := checkEditSequence(edits); err != nil {
+		return err
+	}
+
+	if !changedOnly {
+		return applyEdits(input, Range{0, length}, edits, out)
+	}
+
+	for i, c := range l.changes {
+		if i > 0 && len(sep) > 0 {
+			if _, err := io.WriteString(out, sep); err != nil {
+				return err
+			}
+		}
+		if err := applyEdits(input, c.region, editsWithin(edits, c.region), out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changeCount returns the number of changed regions.
+func (l *EditLog) changeCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.changes)
+}
+
+func (l *EditLog) sortedEdits() []Edit {
+	var edits []Edit
+	for _, c := range l.changes {
+		edits = append(edits, c.edits...)
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Start < edits[j].Start
+	})
+	return edits
+}
+
+func checkEditSequence(edits []Edit) error {
+	for i := 1; i < len(edits); i++ {
+		if edits[i].Start < edits[i-1].End {
+			return fmt.Errorf("Changes not in sequence: %d-%d overlaps %d-%d",
+				edits[i].Start, edits[i].End, edits[i-1].Start, edits[i-1].End)
+		}
+	}
+	return nil
+}
+
+func editsWithin(edits []Edit, r Range) (result []Edit) {
+	for _, e := range edits {
+		if e.Start >= r.Start && e.End <= r.End {
+			result = append(result, e)
+		}
+	}
+	return
+}
+
+// applyEdits writes the text of `r` from `input` to `out`, with `edits` applied.
+// The edits must be sorted and must not overlap.
+func applyEdits(input io.ReaderAt, r Range, edits []Edit, out io.Writer) error {
+	pos := r.Start
+	for _, e := range edits {
+		if err := copyRange(out, input, pos, e.Start); err != nil {
+			return err
+		}
+		if _, err := out.Write(e.Text); err != nil {
+			return err
+		}
+		pos = e.End
+	}
+	return copyRange(out, input, pos, r.End)
+}
+
+func copyRange(out io.Writer, input io.ReaderAt, start, end int64) error {
+	if emptyRange(start, end) {
+		return nil
+	}
+	_, err := io.Copy(out, io.NewSectionReader(input, start, end-start))
+	return err
+}
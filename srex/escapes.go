@@ -0,0 +1,126 @@
+package srex
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unescape replaces the escape sequences in `s` with the characters they stand for. It accepts
+// the escapes of a Go string literal:
+//
+//	\a \b \f \n \r \t \v \\ \' \"  the usual control characters and quotes
+//	\ooo                           a byte with up to three octal digits, so \0 is a NUL byte
+//	\xhh                           a byte with two hexadecimal digits
+//	\uhhhh \Uhhhhhhhh              a Unicode code point, encoded as UTF-8
+//
+// The error for an invalid escape gives the sequence and the position in `s` where it starts,
+// counting characters from 1.
+func Unescape(s string) (string, error) {
+	if !strings.ContainsRune(s, '\\') {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+
+		n, err := unescapeOne(&b, s[i:])
+		if err != nil {
+			return "", fmt.Errorf("%v at character %d", err, utf8.RuneCountInString(s[:i])+1)
+		}
+		i += n
+	}
+	return b.String(), nil
+}
+
+// unescapeOne writes the character for the escape sequence at the start of `s` to `b` and
+// returns the length of the sequence.
+func unescapeOne(b *strings.Builder, s string) (int, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("Incomplete escape sequence '\\'")
+	}
+
+	switch c := s[1]; c {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '\'', '"':
+		b.WriteByte(c)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n, v := 1, 0
+		for ; n < 4 && n < len(s) && s[n] >= '0' && s[n] <= '7'; n++ {
+			v = v*8 + int(s[n]-'0')
+		}
+		if v > 0xff {
+			return 0, fmt.Errorf("Octal escape sequence '%s' is greater than 255", s[:n])
+		}
+		b.WriteByte(byte(v))
+		return n, nil
+	case 'x':
+		v, err := unescapeHex(s, 2)
+		if err != nil {
+			return 0, err
+		}
+		b.WriteByte(byte(v))
+		return 4, nil
+	case 'u', 'U':
+		digits := 4
+		if c == 'U' {
+			digits = 8
+		}
+		v, err := unescapeHex(s, digits)
+		if err != nil {
+			return 0, err
+		}
+		if !utf8.ValidRune(rune(v)) {
+			return 0, fmt.Errorf("Escape sequence '%s' is not a valid Unicode code point", s[:2+digits])
+		}
+		b.WriteRune(rune(v))
+		return 2 + digits, nil
+	default:
+		r, _ := utf8.DecodeRuneInString(s[1:])
+		return 0, fmt.Errorf("Invalid escape sequence '\\%c'", r)
+	}
+	return 2, nil
+}
+
+// unescapeHex returns the value of the `digits` hexadecimal digits that follow the escape
+// character at the start of `s`.
+func unescapeHex(s string, digits int) (int, error) {
+	v := 0
+	for i := 2; i < 2+digits; i++ {
+		if i >= len(s) {
+			return 0, fmt.Errorf("Escape sequence '%s' needs %d hexadecimal digits", s, digits)
+		}
+		d := strings.IndexByte("0123456789abcdef", lower(s[i]))
+		if d < 0 {
+			return 0, fmt.Errorf("Escape sequence '%s' needs %d hexadecimal digits", s[:i+1], digits)
+		}
+		v = v*16 + d
+	}
+	return v, nil
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
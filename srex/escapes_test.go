@@ -0,0 +1,223 @@
+package srex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{
+			name:   "empty",
+			input:  "",
+			output: "",
+		},
+		{
+			name:   "noescapes",
+			input:  "test",
+			output: "test",
+		},
+		{
+			name:   "newline at end",
+			input:  `line\n`,
+			output: "line\n",
+		},
+		{
+			name:   "newline at middle",
+			input:  `line\nline`,
+			output: "line\nline",
+		},
+		{
+			name:   "backslash",
+			input:  `line\\a`,
+			output: `line\a`,
+		},
+		{
+			name:   "control characters",
+			input:  `\a\b\f\n\r\t\v`,
+			output: "\a\b\f\n\r\t\v",
+		},
+		{
+			name:   "quotes",
+			input:  `\'\"`,
+			output: `'"`,
+		},
+		{
+			name:   "nul",
+			input:  `a\0b`,
+			output: "a\x00b",
+		},
+		{
+			name:   "octal",
+			input:  `\101\0123`,
+			output: "A\n3",
+		},
+		{
+			name:   "hex",
+			input:  `\x41\xfF`,
+			output: "A\xff",
+		},
+		{
+			name:   "unicode",
+			input:  `é\U0001F600`,
+			output: "é😀",
+		},
+		{
+			name:   "non-ascii text",
+			input:  `é\té`,
+			output: "é\té",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := Unescape(tc.input)
+			if err != nil {
+				t.Fatalf("Error in escape: %v", err)
+			}
+
+			if o != tc.output {
+				t.Fatalf("Actual %q does not match expected %q", o, tc.output)
+			}
+		})
+	}
+}
+
+func TestUnescapeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "unknown",
+			input: `ab\q`,
+			err:   `Invalid escape sequence '\q' at character 3`,
+		},
+		{
+			name:  "position counts characters",
+			input: `éé\z`,
+			err:   `Invalid escape sequence '\z' at character 3`,
+		},
+		{
+			name:  "trailing backslash",
+			input: `a\`,
+			err:   `Incomplete escape sequence '\' at character 2`,
+		},
+		{
+			name:  "short hex",
+			input: `\x4`,
+			err:   `Escape sequence '\x4' needs 2 hexadecimal digits at character 1`,
+		},
+		{
+			name:  "bad hex",
+			input: `\x4g`,
+			err:   `Escape sequence '\x4g' needs 2 hexadecimal digits at character 1`,
+		},
+		{
+			name:  "octal too large",
+			input: `\400`,
+			err:   `Octal escape sequence '\400' is greater than 255 at character 1`,
+		},
+		{
+			name:  "surrogate",
+			input: `\ud800`,
+			err:   `Escape sequence '\ud800' is not a valid Unicode code point at character 1`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Unescape(tc.input)
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if err.Error() != tc.err {
+				t.Fatalf("Actual error '%v' does not match expected '%s'", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCommandTextEscapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:     "change",
+			program:  `x/b/ c/\t\x41/`,
+			expected: "a \tA c",
+		},
+		{
+			name:     "append",
+			program:  `x/b/ a/\t/`,
+			expected: "a b\t c",
+		},
+		{
+			name:     "insert",
+			program:  `x/b/ i/\t\//`,
+			expected: "a \t/b c",
+		},
+		{
+			name:     "substitute",
+			program:  `s/ (b) /\t\1é&\&\//`,
+			expected: "a\tbé b &/c",
+		},
+		{
+			name:     "substitute octal",
+			program:  `s/b/\0/`,
+			expected: "a \x00 c",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := MustCompile(tc.program).Run(strings.NewReader("a b c"), &WriterSink{Out: &out}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestCommandTextEscapeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		err     string
+	}{
+		{
+			name:    "change",
+			program: `c/a\qb/`,
+			err:     `Command 'c/a\qb/' is malformatted: Invalid escape sequence '\q' at character 2`,
+		},
+		{
+			name:    "substitute",
+			program: `s/a/é\x4/`,
+			err:     `Replacement is malformatted: Escape sequence '\x4' needs 2 hexadecimal digits at character 2`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.program)
+			if err == nil {
+				t.Fatalf("Compile of '%s' succeeded", tc.program)
+			}
+			if err.Error() != tc.err {
+				t.Fatalf("Error '%v' does not match expected '%s'", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package srex
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+)
+
+// unknownLength is the length of a streamed input until the whole stream has been read.
+const unknownLength = math.MaxInt64
+
+// executor executes an ordered sequence of commands
+type executor struct {
+	commands []command
+	wg       sync.WaitGroup
+	// Channels between goroutines in the command pipeline
+	chans       []chan Match
+	input       io.ReaderAt
+	inputLength int64
+	// Sink receives the output of the print command added when the commands don't end in a
+	// terminal command, and the edited input. If it's nil a WriterSink that writes to stdout is used.
+	Sink  Sink
+	edits *EditLog
+	// stream is set when the input is a stream, and releasing when the input can be released as
+	// the ranges are handled.
+	stream    streamInput
+	releasing bool
+	// records is set when the records around the records with output are printed as context.
+	records *contextSink
+	// lines is the index of the lines of the input, if a command or the sink needs one.
+	// nullLines makes the lines end with NUL bytes instead of newlines, and countRunes makes
+	// the offsets and columns count runes instead of bytes.
+	lines      *lineIndex
+	nullLines  bool
+	countRunes bool
+
+	// ctx is canceled when a command fails, to stop the rest of the pipeline. err is the first error.
+	ctx    context.Context
+	cancel context.CancelFunc
+	errMu  sync.Mutex
+	err    error
+}
+
+func newExecutor(commands []command) *executor {
+	return &executor{commands: commands}
+}
+
+// Idea: have two pipelines that are connected:
+// filtering pipeline -> action pipeline
+//
+// filtering pipeline is connected using a series of channels that pass successively more restrictive ranges
+//
+// action pipeline is connected using a series of channels that pass byte buffers for more manipulation
+//
+// Between the two is a connector that reads a range and converts it to a buffer.
+
+// Go runs the commands on `input`. It returns the first error from any of the commands, once
+// all of the stages of the pipeline have stopped.
+func (ex *executor) Go(input io.ReaderAt) error {
+	return ex.GoContext(context.Background(), input)
+}
+
+// GoContext is like Go, but stops the commands if `ctx` is canceled. The commands stop the
+// next time they read the input or pass on a range.
+func (ex *executor) GoContext(ctx context.Context, input io.ReaderAt) error {
+	ex.ctx, ex.cancel = context.WithCancel(ctx)
+	defer ex.cancel()
+	ex.err = nil
+
+	err := ex.prepareToGo(input)
+	if err != nil {
+		return err
+	}
+
+	dbg("%d commands\n", len(ex.commands))
+
+	ex.wg.Add(len(ex.commands))
+
+	for stage := 1; stage < len(ex.commands); stage++ {
+		go ex.doCommandForStage(stage)
+	}
+
+	ex.doCommandForStage(0)
+
+	ex.wg.Wait()
+
+	if ex.err != nil {
+		return ex.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := ex.applyEdits(); err != nil {
+		return err
+	}
+	return ex.printCounts(ex.commands)
+}
+
+func (ex *executor) prepareToGo(input io.ReaderAt) error {
+	if ex.Sink == nil {
+		ex.Sink = &WriterSink{}
+	}
+	ex.commands = ex.addPrintCommandIfNeeded(ex.commands)
+	ex.addPrintCommandsToBlocks(ex.commands)
+	ex.input = input
+	ex.setupEditLog()
+
+	err := ex.findInputLength()
+	if err != nil {
+		return err
+	}
+
+	// The commands read the input through a wrapper that fails once the pipeline is stopped.
+	ex.input = newCancelableInput(ex.ctx, input)
+	ex.setupLineIndex()
+
+	// Setup a pipeline for the commands
+	ex.makeChans(len(ex.commands) - 1)
+
+	return nil
+}
+
+func (ex *executor) doCommandForStage(stage int) {
+	defer ex.wg.Done()
+
+	dbg("Starting stage %d\n", stage)
+
+	if stage == 0 {
+		// First stage reads from the reader directly
+		dbg("Stage %d is reading range %d-%d\n", stage, 0, ex.inputLength)
+		send := ex.writeRangeToChan(ex.firstChan())
+		if ex.releasing || ex.records != nil {
+			send = ex.markRecords(send)
+		}
+		if ex.inputLength == unknownLength {
+			send = ex.streamRanges(send)
+		}
+		ex.fail(ex.commands[stage].Do(ex.input, Match{Range: Range{0, ex.inputLength}}, send))
+	} else {
+		// Later stages read from a pipe. Once the pipeline has been stopped the ranges are
+		// drained without being handled, so that the earlier stages aren't blocked.
+		for rnge := range ex.chans[stage-1] {
+			if ex.stopped() {
+				continue
+			}
+
+			if rnge.isRecordMarker() {
+				ex.forwardRecordMarker(stage, rnge)
+				continue
+			}
+
+			dbg("Stage %d is reading range %d-%d\n", stage, rnge.Start, rnge.End)
+
+			fn := nop
+			if stage < len(ex.commands)-1 {
+				fn = ex.writeRangeToChan(ex.chans[stage])
+			}
+			ex.fail(ex.commands[stage].Do(ex.input, rnge, fn))
+		}
+	}
+
+	if d, ok := ex.commands[stage].(doner); ok && !ex.stopped() {
+		ex.fail(d.Done())
+	}
+
+	if stage < len(ex.chans) {
+		close(ex.chans[stage])
+	}
+}
+
+func (ex *executor) firstChan() chan Match {
+	if len(ex.chans) > 0 {
+		return ex.chans[0]
+	}
+	return nil
+}
+
+func (ex *executor) makeChans(count int) {
+	// Setup a pipeline for the commands
+
+	ex.chans = make([]chan Match, count)
+	for i := range ex.chans {
+		ex.chans[i] = make(chan Match)
+	}
+}
+
+func (ex *executor) writeRangeToChan(c chan Match) func(m Match) {
+	if c == nil {
+		return nop
+	}
+
+	return func(m Match) {
+		dbg("Stage is sending range %d-%d\n", m.Start, m.End)
+		ex.sendRange(c, m)
+	}
+}
+
+// sendRange sends `m` to the next stage on `c`, unless the pipeline is stopped first.
+func (ex *executor) sendRange(c chan Match, m Match) {
+	select {
+	case c <- m:
+	case <-ex.ctx.Done():
+	}
+}
+
+// fail stops the pipeline if `err` isn't nil. The first error is the one that Go returns.
+func (ex *executor) fail(err error) {
+	if err == nil {
+		return
+	}
+
+	ex.errMu.Lock()
+	defer ex.errMu.Unlock()
+
+	if ex.err == nil {
+		dbg("Stopping the pipeline: %v\n", err)
+		ex.err = err
+		ex.cancel()
+	}
+}
+
+// stopped returns true once the pipeline has been stopped, either by a failed command or by
+// the context passed to GoContext.
+func (ex *executor) stopped() bool {
+	return ex.ctx.Err() != nil
+}
+
+func nop(m Match) {
+}
+
+// findInputLength determines the length of the input. The length of a stream isn't known
+// until it has all been read, so if the first command scans its range from start to end the
+// length is left unknown and the commands can start on the stream as it arrives.
+func (ex *executor) findInputLength() (err error) {
+	s, ok := ex.input.(streamInput)
+	if !ok {
+		ex.inputLength, err = lengthOfReaderAt(ex.input)
+		return
+	}
+
+	ex.stream = s
+	if streamsInput(ex.commands[0]) {
+		ex.inputLength = unknownLength
+		ex.releasing = ex.canRelease()
+		dbg("Input is a stream. Releasing input: %v\n", ex.releasing)
+	} else {
+		ex.inputLength, err = s.Size()
+	}
+	return
+}
+
+// streamsInput returns true if the command can be the first command when the length of the
+// input isn't known yet: it scans its range from start to end, and so works on a stream as it arrives.
+func streamsInput(c command) bool {
+	switch c.(type) {
+	case *xCommand, *yCommand, *zCommand:
+		return true
+	}
+	return false
+}
+
+// canRelease returns true if the input before the ranges that have made it through the
+// pipeline can be released: none of the commands may hold on to ranges until they are done,
+// or read the input outside of the ranges they are given. The same goes for the sink.
+func (ex *executor) canRelease() bool {
+	ok := commandsCanRelease(ex.commands)
+	walkBlocks(ex.commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			ok = ok && commandsCanRelease(p)
+		}
+	})
+	return ok
+}
+
+func commandsCanRelease(commands []command) bool {
+	for _, c := range commands {
+		switch c.(type) {
+		case *blockCommand:
+			continue
+		case doner, editor:
+			return false
+		}
+	}
+	return true
+}
+
+// streamRanges wraps the function the first stage uses to send ranges when the input is a
+// stream of unknown length. Ranges that end at the unknown end of the stream are given its actual
+// end.
+func (ex *executor) streamRanges(send func(m Match)) func(m Match) {
+	return func(m Match) {
+		if m.End == unknownLength {
+			var err error
+			m.End, err = ex.stream.Size()
+			if err != nil {
+				ex.fail(err)
+				return
+			}
+			if m.Start >= m.End {
+				return
+			}
+		}
+
+		send(m)
+	}
+}
+
+// markRecords wraps the function the first stage uses to send ranges so that each range is
+// followed by a record marker, when the input is being released or context is being printed.
+func (ex *executor) markRecords(send func(m Match)) func(m Match) {
+	return func(m Match) {
+		send(m)
+		ex.sendRange(ex.firstChan(), recordMarker(m.Range))
+	}
+}
+
+// recordMarker makes a marker that is sent down the pipeline after the range `r` from the first
+// stage. Since the stages handle the ranges in order, once the marker reaches the last stage
+// every range before it has been handled: the input before the end of `r` is no longer needed,
+// and all of the output for `r` has been made.
+func recordMarker(r Range) Match {
+	return Match{Range: r, marker: true}
+}
+
+func (m Match) isRecordMarker() bool {
+	return m.marker
+}
+
+func (ex *executor) forwardRecordMarker(stage int, marker Match) {
+	if stage < len(ex.commands)-1 {
+		ex.sendRange(ex.chans[stage], marker)
+		return
+	}
+
+	if ex.lines != nil {
+		// The lines of the input that may be released are indexed first.
+		ex.fail(ex.lines.extendTo(marker.End))
+	}
+
+	off := marker.End
+	if ex.records != nil {
+		ex.fail(ex.records.recordDone(ex.input, marker.Range))
+		off = ex.records.keepFrom(off)
+	}
+
+	if ex.releasing {
+		dbg("Releasing input before %d\n", off)
+		ex.stream.release(off)
+	}
+}
+
+func (ex *executor) addPrintCommandIfNeeded(commands []command) (result []command) {
+	result = commands
+	if len(commands) == 0 || !isTerminal(commands[len(commands)-1]) {
+		result = append(commands, &printCommand{sink: ex.Sink})
+	}
+	return
+}
+
+// addPrintCommandsToBlocks adds a print command to the pipelines in the blocks in `commands` that need one.
+func (ex *executor) addPrintCommandsToBlocks(commands []command) {
+	walkBlocks(commands, func(b *blockCommand) {
+		for i, p := range b.pipelines {
+			b.pipelines[i] = ex.addPrintCommandIfNeeded(p)
+		}
+	})
+}
+
+// isTerminal returns true if the command is one that ends a pipeline.
+func isTerminal(c command) bool {
+	switch c.(type) {
+	case *printCommand, *printLineCommand, *formatCommand, *aggregateCommand, *blockCommand, editor:
+		return true
+	}
+	return false
+}
+
+// walkBlocks calls fn for each block in `commands`, including the blocks nested in other blocks.
+func walkBlocks(commands []command, fn func(b *blockCommand)) {
+	for _, c := range commands {
+		if b, ok := c.(*blockCommand); ok {
+			fn(b)
+			for _, p := range b.pipelines {
+				walkBlocks(p, fn)
+			}
+		}
+	}
+}
+
+// runPipeline runs the range `in` through the commands in `commands`, which are used as a
+// pipeline inside a block. Unlike the executor's pipeline, the commands all run in the caller's
+// goroutine so that the output for a range from one pipeline in a block comes before the output
+// of the next.
+//
+// The first error from any of the commands is kept in `firstErr`, which belongs to the pipeline
+// rather than the call, since commands such as r pass ranges on later, when they are done. Once
+// there is an error no more ranges are run through the pipeline.
+func runPipeline(commands []command, data io.ReaderAt, in Match, firstErr *error) error {
+	if len(commands) == 0 || *firstErr != nil {
+		return *firstErr
+	}
+
+	next := func(m Match) {
+		runPipeline(commands[1:], data, m, firstErr)
+	}
+
+	if err := commands[0].Do(data, in, next); err != nil && *firstErr == nil {
+		*firstErr = err
+	}
+	return *firstErr
+}
+
+// finishPipeline calls Done for the commands in a pipeline run by runPipeline, in order, so that
+// ranges a command releases when it is done pass through the rest of the pipeline. It returns
+// the first error of the pipeline, including the errors of the ranges released.
+func finishPipeline(commands []command, firstErr *error) error {
+	for _, c := range commands {
+		if *firstErr != nil {
+			break
+		}
+		if d, ok := c.(doner); ok {
+			if err := d.Done(); err != nil && *firstErr == nil {
+				*firstErr = err
+			}
+		}
+	}
+	return *firstErr
+}
+
+// Matches returns the number of ranges that reached the terminal commands once Go is done: the
+// ranges that were printed, and the ranges that were changed by the editing commands.
+func (ex *executor) Matches() int64 {
+	n := countMatches(ex.commands)
+	walkBlocks(ex.commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			n += countMatches(p)
+		}
+	})
+	return n
+}
+
+func countMatches(commands []command) (n int64) {
+	for _, c := range commands {
+		if cnt, ok := c.(counter); ok {
+			n += cnt.Count()
+		}
+	}
+	return
+}
+
+// setupLineIndex gives the commands and the sink that find line numbers a shared lineIndex, if
+// there are any.
+func (ex *executor) setupLineIndex() {
+	ex.lines = nil
+	share := func(c interface{}) {
+		if l, ok := c.(lineIndexer); ok {
+			if ex.lines == nil {
+				ex.lines = newLineIndex(ex.input, lineEnd(ex.nullLines))
+				ex.lines.countRunes = ex.countRunes
+			}
+			l.setLineIndex(ex.lines)
+		}
+	}
+
+	share(ex.Sink)
+	if records, ok := ex.Sink.(*contextSink); ok {
+		share(records.Sink)
+	}
+	for _, c := range ex.commands {
+		share(c)
+	}
+	walkBlocks(ex.commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			for _, c := range p {
+				share(c)
+			}
+		}
+	})
+}
+
+// setupEditLog gives the editing commands, if there are any, a shared EditLog.
+func (ex *executor) setupEditLog() {
+	ex.edits = nil
+	ex.setupEditLogFor(ex.commands)
+	walkBlocks(ex.commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			ex.setupEditLogFor(p)
+		}
+	})
+}
+
+func (ex *executor) setupEditLogFor(commands []command) {
+	for _, c := range commands {
+		if e, ok := c.(editor); ok {
+			if ex.edits == nil {
+				ex.edits = &EditLog{}
+			}
+			e.SetEditLog(ex.edits)
+		}
+	}
+}
+
+// printCounts outputs the counts of the # commands in `commands`, in the order the commands
+// appear in the pipelines.
+func (ex *executor) printCounts(commands []command) error {
+	for _, c := range commands {
+		switch c := c.(type) {
+		case *countCommand:
+			if err := printCount(c.sink, c.Passed()); err != nil {
+				return err
+			}
+		case *blockCommand:
+			for _, p := range c.pipelines {
+				if err := ex.printCounts(p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyEdits outputs the input with the edits made by the editing commands applied.
+func (ex *executor) applyEdits() error {
+	if ex.edits == nil {
+		return nil
+	}
+
+	if ex.inputLength == unknownLength {
+		var err error
+		ex.inputLength, err = ex.stream.Size()
+		if err != nil {
+			return err
+		}
+	}
+
+	return ex.Sink.Edited(ex.input, ex.inputLength, ex.edits)
+}
@@ -0,0 +1,789 @@
+package srex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLengthOfReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "single",
+			input: "l",
+		},
+		{
+			name:  "normal",
+			input: "snarfle",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := []byte(tc.input)
+			rdr := bytes.NewReader(buf)
+
+			l, err := lengthOfReaderAt(rdr)
+			if err != nil {
+				t.Fatalf("Error getting length of reader: '%v'", err)
+			}
+
+			if l != int64(len(buf)) {
+				t.Fatalf("Actual '%d' does not match expected '%d'", l, len(buf))
+			}
+		})
+	}
+
+}
+
+func TestExtractCommandParameterText(t *testing.T) {
+	s, err := extractCommandParameter("x/blah/", '/', '/')
+	if err != nil {
+		t.Fatalf("Extracting text from x/blah/ failed: %v\n", err)
+	}
+
+	if s != "blah" {
+		t.Fatalf("Extracted bad text: '%s'\n", s)
+	}
+
+	s, err = extractCommandParameter("n[blah]", '[', ']')
+	if err != nil {
+		t.Fatalf("Extracting text from n[blah] failed: %v\n", err)
+	}
+
+	if s != "blah" {
+		t.Fatalf("Extracted bad text: '%s'\n", s)
+	}
+
+}
+
+func TestPrintCommand(t *testing.T) {
+
+	buf := []byte("test!")
+	rdr := bytes.NewReader(buf)
+
+	var out bytes.Buffer
+	p := newPrintCommand(&out, "")
+
+	l, err := lengthOfReaderAt(rdr)
+	if err != nil {
+		t.Fatalf("Error getting length of reader: '%v'", err)
+	}
+
+	p.Do(rdr, Match{Range: Range{0, l}}, func(m Match) {})
+
+	if out.String() != "test!" {
+		t.Fatalf("Actual does not match expected: '%s'", out.String())
+	}
+
+}
+
+func TestXCommand(t *testing.T) {
+	var c xCommand
+	var err error
+
+	tests := []struct {
+		name     string
+		input    string
+		regex    string
+		expected Range
+		failed   bool
+	}{
+		{
+			name:     "simple",
+			input:    "line1\ntest",
+			regex:    "ine",
+			expected: Range{1, 4},
+		},
+		{
+			name:   "nonmatch",
+			input:  "loom1\ntest",
+			regex:  "ine",
+			failed: true,
+		},
+		{
+			name:     "multiline",
+			input:    "loom1\ntest",
+			regex:    "1ab",
+			expected: Range{4, 7},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c.regexp, err = regexp.Compile(tc.regex)
+			if err != nil {
+				t.Fatalf("Error making regex: %s\n", err)
+			}
+
+			buf := []byte(tc.input)
+			rdr := bytes.NewReader(buf)
+
+			l, err := lengthOfReaderAt(rdr)
+			if err != nil {
+				t.Fatalf("Error getting length of reader: '%v'", err)
+			}
+
+			c.Do(rdr, Match{Range: Range{0, l}}, func(m Match) {
+				if tc.failed {
+					t.Fatalf("Do called when the match failed\n")
+				}
+				if m.Start != tc.expected.Start || m.End != tc.expected.End {
+					t.Fatalf("start and end does not match expected: %d, %d\n", m.Start, m.End)
+				}
+			})
+		})
+	}
+}
+
+func TestExecutor(t *testing.T) {
+	output := &bytes.Buffer{}
+
+	tests := []struct {
+		name     string
+		input    string
+		cmds     []command
+		expected string
+	}{
+		{
+			name:     "simple",
+			input:    "line1\ntest",
+			cmds:     []command{mustRegexpCommand('x', regexp.MustCompile("ine")), newPrintCommand(output, "")},
+			expected: "ine",
+		},
+		{
+			name:     "simple noprint",
+			input:    "line1\ntest",
+			cmds:     []command{mustRegexpCommand('x', regexp.MustCompile("ine"))},
+			expected: "ine",
+		},
+		{
+			name:  "x matches multiple lines",
+			input: "line1\ntest\nline2",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*line.*")),
+				newPrintCommand(output, "")},
+			expected: "line1line2",
+		},
+		{
+			name:  "x then g",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*line.*")),
+				mustRegexpCommand('g', regexp.MustCompile("1|3")),
+				newPrintCommand(output, "")},
+			expected: "line1line3",
+		},
+		{
+			name:  "x then x",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*line.*")),
+				mustRegexpCommand('x', regexp.MustCompile("1|3")),
+				newPrintCommand(output, "")},
+			expected: "13",
+		},
+		{
+			name:     "no commands",
+			input:    "line1\nline2\nline3",
+			cmds:     []command{},
+			expected: "line1\nline2\nline3",
+		},
+		{
+			name:  "y match",
+			input: "line1\ntest\nline2",
+			cmds: []command{
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "line1\n\nline2",
+		},
+		{
+			name:  "y match at beginning",
+			input: "test\nline2",
+			cmds: []command{
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "\nline2",
+		},
+		{
+			name:  "y no match",
+			input: "line1\n",
+			cmds: []command{
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "line1\n",
+		},
+		{
+			name:  "y multi match",
+			input: "line1\ntest\nline2\ntest",
+			cmds: []command{
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "line1\n\nline2\n",
+		},
+		{
+			name:  "y multi match 2",
+			input: "line1\ntest\nline2\ntestarr",
+			cmds: []command{
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "line1\n\nline2\narr",
+		},
+
+		{
+			name:  "z match",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n3) Entry 1\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('z', regexp.MustCompile(`\d\)`)),
+				newPrintCommand(output, "")},
+			expected: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n3) Entry 1\n  indented\n",
+		},
+		{
+			name:  "z match with select",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n3) Entry 1\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('z', regexp.MustCompile(`\d\)`)),
+				mustRegexpCommand('g', regexp.MustCompile(`2`)),
+				newPrintCommand(output, "")},
+			expected: "2) Entry 2\n  indented\n",
+		},
+		{
+			name:  "z no match",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n3) Entry 1\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('z', regexp.MustCompile(`verb`)),
+				newPrintCommand(output, "")},
+			expected: "",
+		},
+		{
+			name:  "empty input, nonempty x",
+			input: "",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "",
+		},
+		{
+			name:  "empty input, empty x",
+			input: "",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("")),
+				newPrintCommand(output, "")},
+			expected: "",
+		},
+		{
+			name:  "empty input, empty chain",
+			input: "",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("test")),
+				mustRegexpCommand('y', regexp.MustCompile("test")),
+				mustRegexpCommand('g', regexp.MustCompile("test")),
+				mustRegexpCommand('v', regexp.MustCompile("test")),
+				newPrintCommand(output, "")},
+			expected: "",
+		},
+		{
+			name:  "all xml tags, except paragraphs",
+			input: "<html><body><p>test</p><b>bold</b><p>p2</p></body></html>",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("<[^>]+>")),
+				mustRegexpCommand('v', regexp.MustCompile("p>")),
+				newPrintCommand(output, "")},
+			expected: "<html><body><b></b></body></html>",
+		},
+		{
+			name:  "all xml tags having more than one letter",
+			input: "<html><body><p>test</p><b>bold</b><p>p2</p></body></html>",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("<[^>]+>")),
+				mustRegexpCommand('g', regexp.MustCompile("[^</]{2}>")),
+				newPrintCommand(output, "")},
+			expected: "<html><body></body></html>",
+		},
+		{
+			name:  "x then g separator ;",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*line.*")),
+				mustRegexpCommand('g', regexp.MustCompile("1|3")),
+				newPrintCommand(output, ";")},
+			expected: "line1;line3",
+		},
+		{
+			name:  "no match separator ;",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*smoke.*")),
+				newPrintCommand(output, ";")},
+			expected: "",
+		},
+		{
+			name:  "test print line",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line3")),
+				newPrintLineCommand("testfile", output)},
+			expected: "testfile:3\n",
+		},
+		{
+			name:  "test print line 2",
+			input: "line1\nline2\nline3\nline4",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line3\nline4")),
+				newPrintLineCommand("testfile", output)},
+			expected: "testfile:3,4\n",
+		},
+		{
+			name: "test print line multi-line records",
+			input: `1) Entry 1
+  indented 
+2) Entry 2
+  indented   
+3) Entry 3
+  indented
+`,
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(`\d+\) Entry.*\n( +.*\n)*`)),
+				newPrintLineCommand("temp", output)},
+			expected: `temp:1,3
+temp:3,5
+temp:5,7
+`,
+		},
+		{
+			name: "test print line two-line records",
+			input: `1) Entry 1
+  indented 
+2) Entry 2
+  indented   
+3) Entry 3
+  indented
+`,
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(`\d+\) Entry.*\n( +.*)*`)),
+				newPrintLineCommand("temp", output)},
+			expected: `temp:1,2
+temp:3,4
+temp:5,6
+`,
+		},
+		{
+			name:  "n 1",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("1")},
+			expected: "line2",
+		},
+		{
+			name:  "n -1",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("-1")},
+			expected: "line5",
+		},
+		{
+			name:  "n single line too big",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("20")},
+			expected: "",
+		},
+		{
+			name:  "n start and end",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("1:3")},
+			expected: "line2line3line4",
+		},
+		{
+			name:  "n 3 and 4",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("3:4")},
+			expected: "line4line5",
+		},
+		{
+			name:  "n 2 to end",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("2:")},
+			expected: "line3line4line5",
+		},
+		{
+			name:  "n 2 to second last",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("2:-2")},
+			expected: "line3line4",
+		},
+		{
+			name:  "s in x",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line[13]")),
+				mustSubstituteCommand("line", "LINE", false)},
+			expected: "LINE1\nline2\nLINE3",
+		},
+		{
+			name:     "s first match only",
+			input:    "aaa",
+			cmds:     []command{mustSubstituteCommand("a", "b", false)},
+			expected: "baa",
+		},
+		{
+			name:     "s global",
+			input:    "aaa\naa",
+			cmds:     []command{mustSubstituteCommand("a", "b", true)},
+			expected: "bbb\nbb",
+		},
+		{
+			name:     "s global anchored",
+			input:    "aaa",
+			cmds:     []command{mustSubstituteCommand("^a", "X", true)},
+			expected: "Xaa",
+		},
+		{
+			name:     "s global word boundary",
+			input:    "one two",
+			cmds:     []command{mustSubstituteCommand(`\bt`, "T", true)},
+			expected: "one Two",
+		},
+		{
+			name:     "s global empty matches",
+			input:    "abc",
+			cmds:     []command{mustSubstituteCommand("x*", "-", true)},
+			expected: "-a-b-c-",
+		},
+		{
+			name:     "s global empty match after match",
+			input:    "baaac",
+			cmds:     []command{mustSubstituteCommand("a*", "-", true)},
+			expected: "-b-c-",
+		},
+		{
+			name:  "s global anchored in x",
+			input: "aa\naa\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+				mustSubstituteCommand("^a", "X", true)},
+			expected: "Xa\nXa\n",
+		},
+		{
+			name:  "s groups",
+			input: "Event:E_DEBUG, length:38\nEvent:E_MTS_RX, length:60\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+				mustSubstituteCommand(`Event:(\w+), length:(\d+)`, `\2 [&] \1\\`, false)},
+			expected: "38 [Event:E_DEBUG, length:38] E_DEBUG\\\n60 [Event:E_MTS_RX, length:60] E_MTS_RX\\\n",
+		},
+		{
+			name:  "s after g",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('z', regexp.MustCompile(`\d\)`)),
+				mustRegexpCommand('g', regexp.MustCompile(`2`)),
+				mustSubstituteCommand(`indented`, `moved`, false)},
+			expected: "1) Entry 1\n  indented\n2) Entry 2\n  moved\n",
+		},
+		{
+			name:  "c",
+			input: "line1\nline2\nline3",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line2")),
+				mustTextCommand('c', []byte("changed"))},
+			expected: "line1\nchanged\nline3",
+		},
+		{
+			name:  "a and i",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(`\d\) .*\n( +.*\n)*`)),
+				mustRegexpCommand('g', regexp.MustCompile(`2`)),
+				mustRegexpCommand('x', regexp.MustCompile(`Entry`)),
+				mustTextCommand('i', []byte("<")),
+			},
+			expected: "1) Entry 1\n  indented\n2) <Entry 2\n  indented\n",
+		},
+		{
+			name:  "a",
+			input: "line1\nline2\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+				mustTextCommand('a', []byte("--\n"))},
+			expected: "line1\n--\nline2\n--\n",
+		},
+		{
+			name:  "d",
+			input: "1) Entry 1\n  indented\n2) Entry 2\n  indented\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(`\d\) .*\n( +.*\n)*`)),
+				mustRegexpCommand('v', regexp.MustCompile(`2`)),
+				&deleteCommand{}},
+			expected: "2) Entry 2\n  indented\n",
+		},
+		{
+			name:  "block",
+			input: "line1\nline2\nline3\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+				newBlockCommand(
+					[]command{mustRegexpCommand('g', regexp.MustCompile("[13]")), newPrintCommand(output, "")},
+					[]command{mustRegexpCommand('v', regexp.MustCompile("3")), newPrintLineCommand("testfile", output)},
+				)},
+			expected: "line1\ntestfile:1,2\ntestfile:2,3\nline3\n",
+		},
+		{
+			name:  "block with implicit print and n",
+			input: "line1\nline2\nline3\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+				newBlockCommand(
+					[]command{mustRegexpCommand('x', regexp.MustCompile("line"))},
+					[]command{mustNCommand("-1")},
+				)},
+			expected: "linelinelineline3\n",
+		},
+		{
+			name:  "block of edits",
+			input: "line1\nline2\n",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.")),
+				newBlockCommand(
+					[]command{mustTextCommand('i', []byte("<"))},
+					[]command{mustTextCommand('a', []byte(">"))},
+				)},
+			expected: "<line1>\n<line2>\n",
+		},
+		{
+			name:  "n invalid range",
+			input: "line1\nline2\nline3\nline4\nline5",
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile("line.*")),
+				mustNCommand("3:-3")},
+			expected: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			buf := strings.NewReader(tc.input)
+
+			output.Reset()
+			ex := newExecutor(tc.cmds)
+			ex.Sink = &WriterSink{Out: output}
+			ex.Go(buf)
+
+			s := output.String()
+			//t.Logf("Expected '%s' and got '%s'", tc.expected, s)
+			if s != tc.expected {
+				t.Fatalf("Expected '%s' but got '%s'", tc.expected, s)
+			}
+
+		})
+	}
+}
+
+func TestExecutorChangedOnly(t *testing.T) {
+	output := &bytes.Buffer{}
+
+	cmds := []command{
+		mustRegexpCommand('x', regexp.MustCompile(".*\n")),
+		mustSubstituteCommand("line", "LINE", false)}
+
+	ex := newExecutor(cmds)
+	ex.Sink = &WriterSink{Out: output, Sep: ";", ChangedOnly: true}
+	err := ex.Go(strings.NewReader("line1\nother\nline3\n"))
+	if err != nil {
+		t.Fatalf("Executor failed: %v", err)
+	}
+
+	expected := "LINE1\n;LINE3\n"
+	if output.String() != expected {
+		t.Fatalf("Expected '%s' but got '%s'", expected, output.String())
+	}
+}
+
+// failingReaderAt is a ReaderAt for `data` whose reads fail at and after the offset `failAt`.
+type failingReaderAt struct {
+	data   string
+	failAt int64
+}
+
+var errFailingRead = errors.New("read failed")
+
+func (r failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > r.failAt {
+		return 0, errFailingRead
+	}
+	return strings.NewReader(r.data).ReadAt(p, off)
+}
+
+func (r failingReaderAt) Size() int64 {
+	return int64(len(r.data))
+}
+
+// failingSink is a Sink whose Print fails once it has been called `limit` times, if limit isn't 0.
+type failingSink struct {
+	rangeSink
+	limit int
+}
+
+var errFailingSink = errors.New("sink failed")
+
+func (s *failingSink) Print(data io.ReaderAt, m Match) error {
+	s.rangeSink.Print(data, m)
+	if s.limit > 0 && len(s.printed) >= s.limit {
+		return errFailingSink
+	}
+	return nil
+}
+
+func TestExecutorErrors(t *testing.T) {
+	input := strings.Repeat("line1\nline2\nline3\n", 1000)
+
+	tests := []struct {
+		name    string
+		input   io.ReaderAt
+		cmds    []command
+		sink    *failingSink
+		ctx     func() context.Context
+		err     error
+		printed int
+	}{
+		{
+			name:  "read error in x",
+			input: failingReaderAt{input, 100},
+			cmds:  []command{mustRegexpCommand('x', regexp.MustCompile(`line\d\n`))},
+			sink:  &failingSink{},
+			err:   errFailingRead,
+		},
+		{
+			name:  "read error in g",
+			input: failingReaderAt{input, 8000},
+			cmds: []command{
+				mustAddressCommand("1,$"),
+				mustRegexpCommand('g', regexp.MustCompile(`line4`))},
+			sink: &failingSink{},
+			err:  errFailingRead,
+		},
+		{
+			name:  "sink error stops the pipeline",
+			input: strings.NewReader(input),
+			cmds: []command{
+				mustRegexpCommand('x', regexp.MustCompile(`line\d\n`)),
+				mustRegexpCommand('g', regexp.MustCompile(`[13]`))},
+			sink:    &failingSink{limit: 2},
+			err:     errFailingSink,
+			printed: 2,
+		},
+		{
+			name:  "canceled",
+			input: strings.NewReader(input),
+			cmds:  []command{mustRegexpCommand('x', regexp.MustCompile(`line\d\n`))},
+			sink:  &failingSink{},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			err: context.Canceled,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+
+			ex := newExecutor(tc.cmds)
+			ex.Sink = tc.sink
+			err := ex.GoContext(ctx, tc.input)
+			if err != tc.err {
+				t.Fatalf("Expected error '%v' but got '%v'", tc.err, err)
+			}
+
+			if tc.printed > 0 && len(tc.sink.printed) != tc.printed {
+				t.Fatalf("Expected %d ranges to be printed but got %d", tc.printed, len(tc.sink.printed))
+			}
+		})
+	}
+}
+
+func mustSubstituteCommand(re, repl string, global bool) *substituteCommand {
+	c, err := newSubstituteCommand(regexp.MustCompile(re), repl, global)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustRegexpCommand(label rune, re *regexp.Regexp) command {
+	c, err := newRegexpCommand(label, re)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustTextCommand(label rune, text []byte) command {
+	c, err := newTextCommand(label, text)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustNCommand(s string) *nCommand {
+	c, err := newNCommand(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func mustAddressCommand(s string) *addressCommand {
+	c, err := newAddressCommand(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// TestBlockDoneErrors tests that the errors of the ranges that commands such as r pass on when
+// they are done are returned from a block as they are from the executor's pipeline.
+func TestBlockDoneErrors(t *testing.T) {
+	programs := []string{
+		`x/line\d/ r f/{text}/`,
+		`x/line\d/ { r f/{text}/ }`,
+		`x/line\d/ { p o f/{text}/ }`,
+		`x/line\d/ { n[0] { r f/{text}/ } }`,
+	}
+
+	for _, program := range programs {
+		err := MustCompile(program).Run(strings.NewReader("line1\nline2\n"), &coreSink{})
+		if err == nil {
+			t.Fatalf("Expected an error from '%s' with a sink that isn't a TextPrinter", program)
+		}
+	}
+}
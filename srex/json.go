@@ -0,0 +1,189 @@
+package srex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JSONSink is a Sink that writes each range as a JSON object on a line of its own, in the JSON
+// Lines format. An object looks like:
+//
+//	{"file":"f.log","start":0,"end":12,"start_line":1,"end_line":2,"start_column":1,
+//	 "end_column":1,"text":"...","groups":[{"start":0,"end":12,"text":"..."},null],
+//	 "named":{"opc":{...}}}
+//
+// The groups are the submatches of the last regexp command that matched the range, starting with
+// the whole match; a subexpression that didn't take part in the match is null. The named groups
+// are taken from all of the regexp commands. The ranges printed by the forms of = have no text
+// or groups, and only those printed by =+ have columns. The ranges formatted by f have the text
+// of the template as their text, and no columns or groups. The offsets are always in bytes.
+//
+// The count from the # command is output as {"file":"f.log","count":3}, and the aggregates from
+// the k command as {"file":"f.log","key":"LINK","count":3,"min":1,"max":9,"sum":15}.
+type JSONSink struct {
+	// Out is where the output is written. If it's nil the output is written to stdout.
+	Out io.Writer
+	// Name is the name of the input, which is included in each object unless it's empty.
+	Name string
+
+	lines *lineIndex
+}
+
+type jsonRange struct {
+	File      string                `json:"file,omitempty"`
+	Start     int64                 `json:"start"`
+	End       int64                 `json:"end"`
+	StartLine int                   `json:"start_line"`
+	EndLine   int                   `json:"end_line"`
+	StartCol  int                   `json:"start_column,omitempty"`
+	EndCol    int                   `json:"end_column,omitempty"`
+	Text      *string               `json:"text,omitempty"`
+	Groups    []*jsonGroup          `json:"groups,omitempty"`
+	Named     map[string]*jsonGroup `json:"named,omitempty"`
+}
+
+type jsonCount struct {
+	File  string `json:"file,omitempty"`
+	Count int64  `json:"count"`
+}
+
+type jsonAggregate struct {
+	File  string   `json:"file,omitempty"`
+	Key   string   `json:"key"`
+	Count int64    `json:"count"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Sum   *float64 `json:"sum,omitempty"`
+}
+
+type jsonGroup struct {
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Text  string `json:"text"`
+}
+
+func (s *JSONSink) Print(data io.ReaderAt, m Match) error {
+	buf, err := readRange(data, m.Start, m.End)
+	if err != nil {
+		return err
+	}
+
+	obj := jsonRange{File: s.Name, Start: m.Start, End: m.End}
+	text := string(buf)
+	obj.Text = &text
+
+	if s.lines == nil {
+		s.lines = newLineIndex(data, '\n')
+	}
+	obj.StartLine, obj.StartCol, err = s.lines.position(m.Start)
+	if err != nil {
+		return err
+	}
+	obj.EndLine, obj.EndCol, err = s.lines.position(m.End)
+	if err != nil {
+		return err
+	}
+
+	if m.Groups != nil {
+		if err := s.addGroups(&obj, data, m.Groups); err != nil {
+			return err
+		}
+	}
+
+	return s.write(obj)
+}
+
+func (s *JSONSink) addGroups(obj *jsonRange, data io.ReaderAt, g *Groups) error {
+	group := func(r Range, ok bool) (*jsonGroup, error) {
+		if !ok {
+			return nil, nil
+		}
+		buf, err := readRange(data, r.Start, r.End)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonGroup{r.Start, r.End, string(buf)}, nil
+	}
+
+	for i := 0; i < g.Len(); i++ {
+		jg, err := group(g.Group(i))
+		if err != nil {
+			return err
+		}
+		obj.Groups = append(obj.Groups, jg)
+	}
+
+	for _, name := range g.Names() {
+		jg, err := group(g.Named(name))
+		if err != nil {
+			return err
+		}
+		if obj.Named == nil {
+			obj.Named = map[string]*jsonGroup{}
+		}
+		obj.Named[name] = jg
+	}
+	return nil
+}
+
+func (s *JSONSink) PrintLocation(loc Location) error {
+	obj := jsonRange{File: s.Name, Start: loc.Range.Start, End: loc.Range.End, StartLine: loc.Line, EndLine: loc.EndLine}
+	if loc.Format == PositionFormat {
+		obj.StartCol, obj.EndCol = loc.Col, loc.EndCol
+	}
+	return s.write(obj)
+}
+
+func (s *JSONSink) PrintText(m Match, text []byte) error {
+	t := string(text)
+	obj := jsonRange{File: s.Name, Start: m.Start, End: m.End, Text: &t}
+	if s.lines != nil {
+		var err error
+		if obj.StartLine, err = s.lines.line(m.Start); err != nil {
+			return err
+		}
+		if obj.EndLine, err = s.lines.line(m.End); err != nil {
+			return err
+		}
+	}
+	return s.write(obj)
+}
+
+func (s *JSONSink) PrintCount(n int64) error {
+	return s.write(jsonCount{File: s.Name, Count: n})
+}
+
+func (s *JSONSink) PrintAggregate(a Aggregate) error {
+	obj := jsonAggregate{File: s.Name, Key: a.Key, Count: a.Count}
+	if a.Values > 0 {
+		obj.Min, obj.Max, obj.Sum = &a.Min, &a.Max, &a.Sum
+	}
+	return s.write(obj)
+}
+
+func (s *JSONSink) Edited(data io.ReaderAt, length int64, edits *EditLog) error {
+	return fmt.Errorf("The edited input can't be output as JSON")
+}
+
+func (s *JSONSink) write(obj interface{}) error {
+	out := s.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return err
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func (s *JSONSink) setLineIndex(x *lineIndex) {
+	s.lines = x
+}
@@ -0,0 +1,203 @@
+package srex
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+)
+
+// lineEnd returns the byte that ends each line of the input: a newline, or a NUL byte if
+// `nullLines` is set.
+func lineEnd(nullLines bool) byte {
+	if nullLines {
+		return 0
+	}
+	return '\n'
+}
+
+// useNullLines makes the commands that count lines treat NUL bytes as the ends of lines.
+func useNullLines(commands []command) {
+	for _, c := range commands {
+		switch c := c.(type) {
+		case *addressCommand:
+			c.nullLines = true
+		case *blockCommand:
+			for _, p := range c.pipelines {
+				useNullLines(p)
+			}
+		}
+	}
+}
+
+// lineIndexer is implemented by the commands and sinks that find the lines of offsets in the
+// input. The executor gives them all the same lineIndex.
+type lineIndexer interface {
+	setLineIndex(x *lineIndex)
+}
+
+// lineIndex holds the offsets of the ends of the lines of the input, so that the line that
+// contains an offset is found with a binary search instead of by counting the lines before it.
+// The index is built as it's needed, by scanning the input from where the last scan stopped up
+// to the offset asked about. It may be used from more than one goroutine.
+type lineIndex struct {
+	mu   sync.Mutex
+	data io.ReaderAt
+	// sep is the byte that ends each line.
+	sep byte
+	// ends are the offsets of the sep bytes in the input before scanned.
+	ends    []int64
+	scanned int64
+	buf     []byte
+
+	// countRunes is set if the index also counts the runes, the UTF-8 characters, in the input.
+	// marks then hold the number of runes before the start of each line and before each offset
+	// a scan stopped at, so that the runes before an offset are counted from the nearest mark
+	// instead of from the start of the input. runes is the number of runes before scanned.
+	countRunes bool
+	marks      []runeMark
+	runes      int64
+}
+
+// runeMark is the number of runes before an offset in the input.
+type runeMark struct {
+	off, runes int64
+}
+
+func newLineIndex(data io.ReaderAt, sep byte) *lineIndex {
+	return &lineIndex{data: data, sep: sep}
+}
+
+// line returns the number of the line that contains the byte at offset `off`. The lines are
+// numbered from 1.
+func (x *lineIndex) line(off int64) (int, error) {
+	line, _, err := x.position(off)
+	return line, err
+}
+
+// position returns the numbers of the line and the column of the byte at offset `off`. Both
+// are numbered from 1, and the columns count bytes, or runes if the index counts runes.
+func (x *lineIndex) position(off int64) (line, col int, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err = x.scanTo(off); err != nil {
+		return
+	}
+
+	// The number of line ends before off is the index of the first one at or after it.
+	n := sort.Search(len(x.ends), func(i int) bool { return x.ends[i] >= off })
+	start := int64(0)
+	if n > 0 {
+		start = x.ends[n-1] + 1
+	}
+
+	if !x.countRunes {
+		return n + 1, int(off-start) + 1, nil
+	}
+
+	startRunes, err := x.runesBefore(start)
+	if err != nil {
+		return
+	}
+	offRunes, err := x.runesBefore(off)
+	return n + 1, int(offRunes-startRunes) + 1, err
+}
+
+// offset returns `off`, or if the index counts runes, the number of runes before it.
+func (x *lineIndex) offset(off int64) (int64, error) {
+	if !x.countRunes {
+		return off, nil
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.scanTo(off); err != nil {
+		return 0, err
+	}
+	return x.runesBefore(off)
+}
+
+// runesBefore returns the number of runes before `off`, which has been scanned.
+func (x *lineIndex) runesBefore(off int64) (int64, error) {
+	i := sort.Search(len(x.marks), func(i int) bool { return x.marks[i].off > off }) - 1
+	mark := runeMark{}
+	if i >= 0 {
+		mark = x.marks[i]
+	}
+	if mark.off == off {
+		return mark.runes, nil
+	}
+
+	buf, err := readRange(x.data, mark.off, off)
+	if err != nil {
+		return 0, err
+	}
+	return mark.runes + runeCount(buf), nil
+}
+
+// runeCount returns the number of runes in `buf`, which is the number of bytes that don't
+// continue a UTF-8 encoding. Unlike utf8.RuneCount it gives the same total for a sequence of
+// buffers whatever the boundaries between them.
+func runeCount(buf []byte) (n int64) {
+	for _, b := range buf {
+		if b&0xc0 != 0x80 {
+			n++
+		}
+	}
+	return
+}
+
+// extendTo scans the input up to offset `off` if it hasn't been scanned that far already.
+// Once the index has been extended past an offset the input before it is no longer read, so
+// the executor extends it before releasing a stream.
+func (x *lineIndex) extendTo(off int64) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.scanTo(off)
+}
+
+func (x *lineIndex) scanTo(off int64) error {
+	if x.buf == nil && x.scanned < off {
+		x.buf = make([]byte, 64*1024)
+	}
+
+	for x.scanned < off {
+		l := int64(len(x.buf))
+		if off-x.scanned < l {
+			l = off - x.scanned
+		}
+
+		n, err := x.data.ReadAt(x.buf[:l], x.scanned)
+		buf, i := x.buf[:n], 0
+		for {
+			j := bytes.IndexByte(buf[i:], x.sep)
+			if j < 0 {
+				break
+			}
+			x.ends = append(x.ends, x.scanned+int64(i+j))
+			if x.countRunes {
+				x.runes += runeCount(buf[i : i+j+1])
+				x.marks = append(x.marks, runeMark{x.scanned + int64(i+j+1), x.runes})
+			}
+			i += j + 1
+		}
+		if x.countRunes {
+			x.runes += runeCount(buf[i:])
+		}
+		x.scanned += int64(n)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if x.countRunes && (len(x.marks) == 0 || x.marks[len(x.marks)-1].off != x.scanned) {
+		x.marks = append(x.marks, runeMark{x.scanned, x.runes})
+	}
+	return nil
+}
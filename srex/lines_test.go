@@ -0,0 +1,129 @@
+package srex
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineIndex(t *testing.T) {
+	input := "one\ntwo\n\nfour"
+
+	tests := []struct {
+		name string
+		sep  byte
+		// offs are the offsets to find, in the order they're asked about.
+		offs     []int64
+		expected [][2]int
+	}{
+		{
+			name:     "in order",
+			sep:      '\n',
+			offs:     []int64{0, 2, 3, 4, 8, 9, 12, 13},
+			expected: [][2]int{{1, 1}, {1, 3}, {1, 4}, {2, 1}, {3, 1}, {4, 1}, {4, 4}, {4, 5}},
+		},
+		{
+			name:     "out of order",
+			sep:      '\n',
+			offs:     []int64{9, 0, 13, 5},
+			expected: [][2]int{{4, 1}, {1, 1}, {4, 5}, {2, 2}},
+		},
+		{
+			name:     "past the end",
+			sep:      '\n',
+			offs:     []int64{20},
+			expected: [][2]int{{4, 12}},
+		},
+		{
+			name:     "nul",
+			sep:      0,
+			offs:     []int64{3, 14},
+			expected: [][2]int{{1, 4}, {1, 15}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x := newLineIndex(strings.NewReader(input), tc.sep)
+			for i, off := range tc.offs {
+				line, col, err := x.position(off)
+				if err != nil {
+					t.Fatalf("position failed: %v", err)
+				}
+				if [2]int{line, col} != tc.expected[i] {
+					t.Fatalf("Expected line and column %v for offset %d but got %d,%d", tc.expected[i], off, line, col)
+				}
+			}
+		})
+	}
+}
+
+// releasedReaderAt is an io.ReaderAt whose reads fail before offset `released`, like a Stream
+// whose start has been released.
+type releasedReaderAt struct {
+	io.ReaderAt
+	released int64
+}
+
+func (r *releasedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.released {
+		return 0, errors.New("read released input")
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestLineIndexAfterRelease(t *testing.T) {
+	input := &releasedReaderAt{ReaderAt: strings.NewReader(strings.Repeat("line\n", 100000))}
+	x := newLineIndex(input, '\n')
+
+	if err := x.extendTo(250000); err != nil {
+		t.Fatalf("extendTo failed: %v", err)
+	}
+	input.released = 250000
+
+	for _, tc := range []struct {
+		off  int64
+		line int
+	}{{0, 1}, {12, 3}, {249999, 50000}, {250000, 50001}, {499999, 100000}} {
+		line, err := x.line(tc.off)
+		if err != nil {
+			t.Fatalf("line failed for offset %d: %v", tc.off, err)
+		}
+		if line != tc.line {
+			t.Fatalf("Expected line %d for offset %d but got %d", tc.line, tc.off, line)
+		}
+	}
+}
+
+func TestLineIndexRunes(t *testing.T) {
+	input := &releasedReaderAt{ReaderAt: strings.NewReader(strings.Repeat("\u00e9t\u00e9\n", 50000))}
+	x := newLineIndex(input, '\n')
+	x.countRunes = true
+
+	if err := x.extendTo(150003); err != nil {
+		t.Fatalf("extendTo failed: %v", err)
+	}
+	input.released = 150003
+
+	for _, tc := range []struct {
+		off       int64
+		line, col int
+		runes     int64
+	}{{0, 1, 1, 0}, {6, 2, 1, 4}, {150003, 25001, 3, 100002}, {150005, 25001, 4, 100003}, {299999, 50000, 4, 199999}} {
+		line, col, err := x.position(tc.off)
+		if err != nil {
+			t.Fatalf("position failed for offset %d: %v", tc.off, err)
+		}
+		if line != tc.line || col != tc.col {
+			t.Fatalf("Expected line and column %d,%d for offset %d but got %d,%d", tc.line, tc.col, tc.off, line, col)
+		}
+		runes, err := x.offset(tc.off)
+		if err != nil {
+			t.Fatalf("offset failed for offset %d: %v", tc.off, err)
+		}
+		if runes != tc.runes {
+			t.Fatalf("Expected %d runes before offset %d but got %d", tc.runes, tc.off, runes)
+		}
+	}
+}
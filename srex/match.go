@@ -0,0 +1,119 @@
+package srex
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Match is a range of the input passed between the commands, along with the submatches of the
+// regexps that selected it.
+type Match struct {
+	Range
+	// Groups are the submatches of the last regexp command that matched the range, or nil if no
+	// regexp command has.
+	Groups *Groups
+
+	// marker is set for the markers the executor sends down the pipeline after each range
+	// from the first command. See recordMarker.
+	marker bool
+}
+
+// Groups are the submatches found by a regexp command: the whole match, which for a command like
+// g is the part of the range that matched, followed by the parenthesized subexpressions. The
+// Groups found by earlier commands in the pipeline are kept, so that named subexpressions from
+// any of them can be looked up.
+type Groups struct {
+	re *regexp.Regexp
+	// locs are the start and end offsets of each submatch in the input, or -1 for a
+	// subexpression that didn't take part in the match.
+	locs []int64
+	prev *Groups
+}
+
+// newGroups returns the Groups for the submatch indexes `locs`, which are relative to `offset`,
+// found by `re` for a range that had the groups `prev`.
+func newGroups(re *regexp.Regexp, locs []int, offset int64, prev *Groups) *Groups {
+	g := &Groups{re: re, locs: make([]int64, len(locs)), prev: prev}
+	for i, l := range locs {
+		g.locs[i] = -1
+		if l >= 0 {
+			g.locs[i] = offset + int64(l)
+		}
+	}
+	return g
+}
+
+// Len returns the number of submatches, including the whole match.
+func (g *Groups) Len() int {
+	return len(g.locs) / 2
+}
+
+// Group returns the range of submatch `i`, and false if the subexpression didn't take part in the match.
+func (g *Groups) Group(i int) (Range, bool) {
+	if i < 0 || i >= g.Len() || g.locs[2*i] < 0 {
+		return Range{}, false
+	}
+	return Range{g.locs[2*i], g.locs[2*i+1]}, true
+}
+
+// Name returns the name of submatch `i`, or "" if the subexpression isn't named.
+func (g *Groups) Name(i int) string {
+	names := g.re.SubexpNames()
+	if i < 0 || i >= len(names) {
+		return ""
+	}
+	return names[i]
+}
+
+// Named returns the range of the submatch named `name`. If more than one of the regexps in the
+// pipeline has a subexpression with the name, the one from the last regexp is used. The second
+// result is false if there is no such subexpression, or it didn't take part in the match.
+func (g *Groups) Named(name string) (Range, bool) {
+	for ; g != nil; g = g.prev {
+		if i := g.re.SubexpIndex(name); i >= 0 {
+			return g.Group(i)
+		}
+	}
+	return Range{}, false
+}
+
+// Names returns the names of the named subexpressions of all of the regexps in the pipeline.
+func (g *Groups) Names() (names []string) {
+	seen := map[string]bool{}
+	for ; g != nil; g = g.prev {
+		for _, n := range g.re.SubexpNames() {
+			if n != "" && !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return
+}
+
+// Field returns the range of the submatch `field`, which is either the number of a submatch of
+// the last regexp, or the name of a submatch of any of the regexps as Named looks it up. The
+// second result is false if there is no such submatch, or it didn't take part in the match.
+func (g *Groups) Field(field string) (Range, bool) {
+	if g == nil {
+		return Range{}, false
+	}
+	if i, err := strconv.Atoi(field); err == nil {
+		return g.Group(i)
+	}
+	return g.Named(field)
+}
+
+// validField returns an error if `field` can't be the name or number of a submatch.
+func validField(field string) error {
+	if field == "" {
+		return fmt.Errorf("The field name is missing")
+	}
+	for _, r := range field {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("Field '%s' isn't the name or number of a group. Names may only contain letters, digits and underscores", field)
+		}
+	}
+	return nil
+}
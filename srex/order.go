@@ -0,0 +1,130 @@
+package srex
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// key returns the key that the o and u commands order and compare the range `in` by, along with
+// the range to output. The key is the text captured by the regexp, as the w command captures it,
+// or the text of the whole range if the command has no regexp. If the regexp doesn't match the
+// key is empty, and otherwise the groups of the range to output are those of the regexp.
+func (r *regexpCommand) key(data io.ReaderAt, in Match) ([]byte, Match, error) {
+	if r.regexp == nil {
+		buf, err := readRange(data, in.Start, in.End)
+		return buf, in, err
+	}
+
+	buf, groups, err := r.capture(data, in)
+	if err != nil || groups == nil {
+		return nil, in, err
+	}
+	return buf, Match{Range: in.Range, Groups: groups}, nil
+}
+
+// sortCommand is the o command, which orders the ranges. It holds on to the ranges until the end
+// of the input, and then outputs them sorted by their keys: their text for o, or the text
+// captured by the regexp for o/re/. The sort is stable, so ranges with equal keys are output in
+// the order they came in.
+type sortCommand struct {
+	regexpCommand
+	// numeric sorts the keys as numbers, ignoring the white space around them, with the keys
+	// that aren't numbers first. reverse sorts them from the greatest to the least.
+	numeric, reverse bool
+	ranges           []sortedRange
+	match            func(m Match)
+}
+
+// sortedRange is a range held by a sortCommand, along with its key.
+type sortedRange struct {
+	m   Match
+	key []byte
+	// num is the key as a number, if isNum is set.
+	num   float64
+	isNum bool
+}
+
+func (c *sortCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	key, m, err := c.key(data, in)
+	if err != nil {
+		return err
+	}
+
+	r := sortedRange{m: m, key: key}
+	if c.numeric {
+		r.num, r.isNum = parseNumber(string(bytes.TrimSpace(key)))
+	}
+	c.ranges = append(c.ranges, r)
+	c.match = match
+	return nil
+}
+
+func (c *sortCommand) Done() error {
+	sort.SliceStable(c.ranges, func(i, j int) bool {
+		if c.reverse {
+			return c.less(c.ranges[j], c.ranges[i])
+		}
+		return c.less(c.ranges[i], c.ranges[j])
+	})
+
+	for _, r := range c.ranges {
+		c.match(r.m)
+	}
+	return nil
+}
+
+func (c *sortCommand) less(a, b sortedRange) bool {
+	if c.numeric && (a.isNum || b.isNum) {
+		if a.isNum != b.isNum {
+			return b.isNum
+		}
+		return a.num < b.num
+	}
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// uniqCommand is the u command, which removes duplicate ranges. It outputs each range whose key,
+// its text for u or the text captured by the regexp for u/re/, differs from the keys of all of
+// the ranges before it. Unlike the uniq program the duplicates needn't be next to each other.
+type uniqCommand struct {
+	regexpCommand
+	seen map[string]bool
+}
+
+func (c *uniqCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	key, m, err := c.key(data, in)
+	if err != nil {
+		return err
+	}
+
+	if c.seen[string(key)] {
+		return nil
+	}
+	if c.seen == nil {
+		c.seen = map[string]bool{}
+	}
+	c.seen[string(key)] = true
+	match(m)
+	return nil
+}
+
+// reverseCommand is the r command. It holds on to the ranges until the end of the input, and
+// then outputs them in reverse order.
+type reverseCommand struct {
+	ranges []Match
+	match  func(m Match)
+}
+
+func (c *reverseCommand) Do(data io.ReaderAt, in Match, match func(m Match)) error {
+	c.ranges = append(c.ranges, in)
+	c.match = match
+	return nil
+}
+
+func (c *reverseCommand) Done() error {
+	for i := len(c.ranges) - 1; i >= 0; i-- {
+		c.match(c.ranges[i])
+	}
+	return nil
+}
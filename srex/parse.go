@@ -0,0 +1,653 @@
+package srex
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parseCommands parses the commands in `commands`. The terminal commands output to `sink`.
+func parseCommands(commands string, sink Sink) (result []command, err error) {
+	p := commandParser{sink: sink, tokens: tokenizeCommands(commands)}
+
+	result, err = p.parseSequence(false)
+	if err != nil {
+		return
+	}
+
+	if p.pos < len(p.tokens) {
+		if p.tokens[p.pos] == "}" {
+			err = fmt.Errorf("Unmatched '}'")
+		} else {
+			err = fmt.Errorf("Command '%s' follows a block. Commands may not follow a block", p.tokens[p.pos])
+		}
+	}
+	return
+}
+
+// commandParser builds the commands from the tokens of the command string.
+type commandParser struct {
+	sink   Sink
+	tokens []string
+	pos    int
+}
+
+// parseSequence parses a sequence of commands up to the end of the tokens, a '}', or a block. If
+// `inBlock` is set the sequence is one of the pipelines in a block, and also ends after a terminal command.
+func (p *commandParser) parseSequence(inBlock bool) (result []command, err error) {
+	result = []command{}
+	for p.pos < len(p.tokens) {
+		s := p.tokens[p.pos]
+		if s == "}" {
+			return
+		}
+		p.pos++
+
+		if s == "{" {
+			var block *blockCommand
+			block, err = p.parseBlock()
+			if err != nil {
+				return
+			}
+			result = append(result, block)
+			return
+		}
+
+		var cmd command
+		if isAddressStart([]rune(s)[0]) && s != "#" {
+			if p.pos != 1 {
+				err = fmt.Errorf("Address '%s' is not at the start of the commands. An address may only be the first command", s)
+				return
+			}
+			cmd, err = newAddressCommand(s)
+		} else if s[0] == 'w' {
+			cmd, err = p.parseCompare(s)
+		} else {
+			cmd, err = parseCommand(s, p.sink)
+		}
+		if err != nil {
+			return
+		}
+		result = append(result, cmd)
+
+		if inBlock && isTerminal(cmd) {
+			return
+		}
+	}
+	return
+}
+
+// parseCompare parses the w command `s`. Its comparison is in the tokens that follow it: an
+// operator and a value, which may be in one token such as >50. A value in double quotes, which
+// may contain spaces, has the escapes of a Go string.
+func (p *commandParser) parseCompare(s string) (cmd command, err error) {
+	re, err := parseCommandRegexp(s)
+	if err != nil {
+		return
+	}
+
+	next := func() (string, error) {
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] == "}" {
+			return "", fmt.Errorf("Command '%s' must be followed by a comparison, such as > 50", s)
+		}
+		p.pos++
+		return p.tokens[p.pos-1], nil
+	}
+
+	tok, err := next()
+	if err != nil {
+		return
+	}
+	i := 0
+	for i < len(tok) && strings.IndexByte("<>=!", tok[i]) >= 0 {
+		i++
+	}
+	op, value := tok[:i], tok[i:]
+	if op == "" {
+		err = fmt.Errorf("Comparison '%s' of command '%s' doesn't start with one of <, <=, >, >=, == and !=", tok, s)
+		return
+	}
+	if value == "" {
+		if value, err = next(); err != nil {
+			return
+		}
+	}
+	if strings.HasPrefix(value, `"`) {
+		quoted := value
+		if value, err = strconv.Unquote(quoted); err != nil {
+			err = fmt.Errorf("The value %s compared by command '%s' is not a valid quoted string", quoted, s)
+			return
+		}
+	}
+	return newCompareCommand(re, op, value)
+}
+
+// parseBlock parses the pipelines of a block up to the closing '}'.
+func (p *commandParser) parseBlock() (block *blockCommand, err error) {
+	block = newBlockCommand()
+	for {
+		if p.pos >= len(p.tokens) {
+			err = fmt.Errorf("Block is missing a closing '}'")
+			return
+		}
+
+		if p.tokens[p.pos] == "}" {
+			p.pos++
+			return
+		}
+
+		var cmds []command
+		cmds, err = p.parseSequence(true)
+		if err != nil {
+			return
+		}
+		block.pipelines = append(block.pipelines, cmds)
+	}
+}
+
+// parseCommand parses a single command other than a block.
+func parseCommand(s string, sink Sink) (cmd command, err error) {
+	cmdLabel := []rune(s)[0]
+	switch cmdLabel {
+	case 'x', 'y', 'g', 'v', 'z':
+		var field string
+		field, s, err = extractField(s)
+		if err != nil {
+			return
+		}
+		if len(s) < 3 {
+			err = fmt.Errorf("Command '%s' is malformatted", s)
+			return
+		}
+		var re *regexp.Regexp
+		re, err = parseCommandRegexp(s)
+		if err != nil {
+			return
+		}
+		cmd, err = newRegexpCommand(cmdLabel, re)
+		if err != nil {
+			return
+		}
+		switch c := cmd.(type) {
+		case *gCommand:
+			c.field = field
+		case *vCommand:
+			c.field = field
+		default:
+			if field != "" {
+				err = fmt.Errorf("Command '%c' can't test a field. Only g and v can", cmdLabel)
+			}
+		}
+	case 's':
+		cmd, err = parseSubstituteCommand(s)
+	case 'c', 'a', 'i':
+		var p string
+		p, err = extractRegexpCommandParameter(s)
+		if err != nil {
+			return
+		}
+		if p, err = unescapeCommandText(p); err != nil {
+			err = fmt.Errorf("Command '%s' is malformatted: %v", s, err)
+			return
+		}
+		cmd, err = newTextCommand(cmdLabel, []byte(p))
+	case 'd':
+		if s != "d" {
+			err = fmt.Errorf("Unknown command '%s'", s)
+			return
+		}
+		cmd = &deleteCommand{}
+	case 'p':
+		if s != "p" {
+			err = fmt.Errorf("Unknown command '%s'", s)
+			return
+		}
+		cmd = &printCommand{sink: sink}
+	case '=':
+		switch s {
+		case "=":
+			cmd = &printLineCommand{sink: sink}
+		case "=#":
+			cmd = &printLineCommand{sink: sink, format: OffsetFormat}
+		case "=+":
+			cmd = &printLineCommand{sink: sink, format: PositionFormat}
+		default:
+			err = fmt.Errorf("Command '%s' is malformatted", s)
+		}
+	case '#':
+		if s != "#" {
+			err = fmt.Errorf("Unknown command '%s'", s)
+			return
+		}
+		cmd = &countCommand{sink: sink}
+	case 'f':
+		var p string
+		p, err = extractRegexpCommandParameter(s)
+		if err != nil {
+			return
+		}
+		if p, err = unescapeCommandText(p); err != nil {
+			err = fmt.Errorf("Command '%s' is malformatted: %v", s, err)
+			return
+		}
+		var t *Template
+		t, err = ParseTemplate(p)
+		if err != nil {
+			return
+		}
+		cmd = &formatCommand{sink: sink, template: t}
+	case 'k':
+		var field string
+		field, s, err = extractField(s)
+		if err != nil {
+			return
+		}
+		var re *regexp.Regexp
+		re, err = parseCommandRegexp(s)
+		if err != nil {
+			return
+		}
+		cmd = &aggregateCommand{regexpCommand: regexpCommand{regexp: re}, sink: sink, field: field}
+	case 'o', 'u':
+		cmd, err = parseOrderCommand(s)
+	case 'r':
+		if s != "r" {
+			err = fmt.Errorf("Command '%s' is malformatted", s)
+			return
+		}
+		cmd = &reverseCommand{}
+	case 'n':
+		var p string
+		p, err = extractArraylikeCommandParameter(s)
+		if err != nil {
+			return
+		}
+		cmd, err = newNCommand(p)
+	default:
+		err = fmt.Errorf("Unknown command '%c'", cmdLabel)
+	}
+	return
+}
+
+// extractField removes the field from a command such as g:opc/re/, which tests the group named
+// opc instead of the whole range, and returns the field and the command without it.
+func extractField(command string) (field, rest string, err error) {
+	if len(command) < 2 || command[1] != ':' {
+		return "", command, nil
+	}
+
+	i := strings.IndexByte(command, '/')
+	if i < 0 {
+		i = len(command)
+	}
+	field = command[2:i]
+	if err = validField(field); err != nil {
+		return
+	}
+	return field, command[:1] + command[i:], nil
+}
+
+func tokenizeCommands(commands string) []string {
+	var t tokenizer
+	return t.tokenize(commands)
+}
+
+type tokenizer struct {
+	runes []rune
+	cmd   bytes.Buffer
+	cmds  []string
+}
+
+func (t *tokenizer) tokenize(commands string) []string {
+	t.runes = []rune(commands)
+	t.innerTokenize()
+	return t.cmds
+}
+
+// stripComments replaces the comment lines in the script `script` with empty lines, keeping the
+// other lines as they are.
+func stripComments(script string) string {
+	lines := strings.Split(script, "\n")
+	for i, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		if strings.HasPrefix(line, "#") && (len(line) == 1 || !unicode.IsDigit(rune(line[1]))) {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trailingComment finds a line of the script `script` that looks like it ends with a comment: a #
+// command that is followed on the same line by text that isn't a command. It returns the number
+// of the line, counting from 1, and its text.
+func trailingComment(script string) (n int, line string, ok bool) {
+	for i, line := range strings.Split(stripComments(script), "\n") {
+		tokens := tokenizeCommands(line)
+		for j := 1; j+1 < len(tokens); j++ {
+			if tokens[j] != "#" {
+				continue
+			}
+			if _, err := parseCommand(tokens[j+1], nil); err != nil {
+				return i + 1, strings.TrimSpace(line), true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// commandArgs is the number of delimited arguments taken by the commands that take more than one.
+var commandArgs = map[rune]int{'s': 2}
+
+// commandFlags lists the flags that may directly follow the last argument of a command.
+var commandFlags = map[rune]string{'s': "g", 'o': "nr"}
+
+func (t *tokenizer) innerTokenize() {
+	const (
+		Default = iota
+		WaitingForTerminator
+		EscapeNext
+	)
+
+	var state = Default
+	var terminator rune
+	var args int
+	for i := t.addAddress(); i < len(t.runes); i++ {
+		r := t.runes[i]
+		switch state {
+		case Default:
+			if unicode.IsSpace(r) {
+				if t.cmd.Len() != 0 && !t.awaitsArgument(i) {
+					t.addCommand()
+				}
+				continue
+			}
+			if r == '"' {
+				// A quoted value compared by w, which may contain spaces.
+				if t.cmd.Len() == 0 {
+					args = 1
+				}
+				t.addRuneToCurrentCommand(r)
+				state = WaitingForTerminator
+				terminator = '"'
+				continue
+			}
+			if r == '{' || r == '}' {
+				if t.cmd.Len() != 0 {
+					t.addCommand()
+				}
+				t.addRuneToCurrentCommand(r)
+				t.addCommand()
+				continue
+			}
+			if t.cmd.Len() == 0 {
+				args = 1
+				if n, ok := commandArgs[r]; ok {
+					args = n
+				}
+			}
+			t.addRuneToCurrentCommand(r)
+			switch r {
+			case '/':
+				state = WaitingForTerminator
+				terminator = '/'
+			case '[':
+				state = WaitingForTerminator
+				terminator = ']'
+			}
+		case WaitingForTerminator:
+			t.addRuneToCurrentCommand(r)
+			switch r {
+			case terminator:
+				args--
+				if args > 0 {
+					// The terminator also starts the next argument
+					break
+				}
+				state = Default
+				i = t.addFlags(i)
+				t.addCommand()
+			case '\\':
+				state = EscapeNext
+			}
+		case EscapeNext:
+			t.addRuneToCurrentCommand(r)
+			state = WaitingForTerminator
+		}
+	}
+
+	if t.cmd.Len() != 0 {
+		t.addCommand()
+	}
+}
+
+// argumentCommands are the commands that take a delimited argument, which may be separated from
+// the command by white space, as in x /re/.
+const argumentCommands = "xyzgvscaifkounw"
+
+// awaitsArgument returns true if the current command is only a command letter, or a command
+// letter and field such as g:opc, and the white space at index `i` is followed by its argument.
+func (t *tokenizer) awaitsArgument(i int) bool {
+	cmd := t.cmd.String()
+	if !strings.ContainsRune(argumentCommands, rune(cmd[0])) || len(cmd) > 1 && cmd[1] != ':' {
+		return false
+	}
+
+	delim := '/'
+	if cmd[0] == 'n' {
+		delim = '['
+	}
+	for i < len(t.runes) && unicode.IsSpace(t.runes[i]) {
+		i++
+	}
+	return i < len(t.runes) && t.runes[i] == delim
+}
+
+// addAddress adds the sam address at the start of the commands, if there is one, as the first
+// command and returns the index of the rune that follows it.
+func (t *tokenizer) addAddress() int {
+	i := 0
+	for i < len(t.runes) && unicode.IsSpace(t.runes[i]) {
+		i++
+	}
+
+	start := i
+	for i < len(t.runes) {
+		r := t.runes[i]
+		if r == '/' || r == '?' {
+			i, _ = skipDelimited(t.runes, i)
+		} else if r == '#' && (i+1 >= len(t.runes) || !unicode.IsDigit(t.runes[i+1])) {
+			// # without a number is the count command.
+			break
+		} else if strings.ContainsRune(addressChars, r) {
+			i++
+		} else {
+			break
+		}
+	}
+
+	if i > start {
+		t.cmd.WriteString(string(t.runes[start:i]))
+		t.addCommand()
+	}
+	return i
+}
+
+// addFlags adds the flags following the last argument of the current command, which ends
+// at index `i`, and returns the index of the last flag. A flag character that is directly
+// followed by a delimiter is instead treated as the start of the next command.
+func (t *tokenizer) addFlags(i int) int {
+	flags := commandFlags[[]rune(t.cmd.String())[0]]
+
+	for i+1 < len(t.runes) && strings.ContainsRune(flags, t.runes[i+1]) {
+		if i+2 < len(t.runes) && (t.runes[i+2] == '/' || t.runes[i+2] == '[') {
+			break
+		}
+		i++
+		t.addRuneToCurrentCommand(t.runes[i])
+	}
+	return i
+}
+
+func (t *tokenizer) addRuneToCurrentCommand(r rune) {
+	t.cmd.WriteRune(r)
+}
+
+func (t *tokenizer) addCommand() {
+	t.cmds = append(t.cmds, t.cmd.String())
+	t.cmd.Reset()
+}
+
+func parseCommandRegexp(command string) (re *regexp.Regexp, err error) {
+	reText, err := extractRegexpCommandParameter(command)
+	if err != nil {
+		return
+	}
+	re, err = regexp.Compile(reText)
+	return
+}
+
+// parseOrderCommand parses the o and u commands, which may be followed by a regexp that
+// captures the keys of the ranges, and for o, by the flags n and r. An empty regexp is the same
+// as none, so o//n sorts the ranges by their text as numbers.
+func parseOrderCommand(command string) (cmd command, err error) {
+	var re *regexp.Regexp
+	var flags string
+	if len(command) > 1 {
+		var params []string
+		params, flags, err = extractCommandParameters(command, 1)
+		if err != nil {
+			return
+		}
+		if params[0] != "" {
+			if re, err = regexp.Compile(params[0]); err != nil {
+				return
+			}
+		}
+	}
+
+	if command[0] == 'u' {
+		if flags != "" {
+			err = fmt.Errorf("Command 'u' has invalid flags '%s' (the complete command is: '%s')", flags, command)
+			return
+		}
+		return &uniqCommand{regexpCommand: regexpCommand{regexp: re}}, nil
+	}
+
+	if strings.Trim(flags, "nr") != "" {
+		err = fmt.Errorf("Command 'o' has invalid flags '%s' (the complete command is: '%s')", flags, command)
+		return
+	}
+	return &sortCommand{
+		regexpCommand: regexpCommand{regexp: re},
+		numeric:       strings.Contains(flags, "n"),
+		reverse:       strings.Contains(flags, "r"),
+	}, nil
+}
+
+func parseSubstituteCommand(command string) (cmd command, err error) {
+	params, flags, err := extractCommandParameters(command, 2)
+	if err != nil {
+		return
+	}
+
+	if strings.Trim(flags, "g") != "" {
+		err = fmt.Errorf("Command 's' has invalid flags '%s' (the complete command is: '%s')", flags, command)
+		return
+	}
+
+	re, err := regexp.Compile(params[0])
+	if err != nil {
+		return
+	}
+
+	return newSubstituteCommand(re, params[1], flags != "")
+}
+
+// unescapeCommandText decodes the text parameter of the f, c, a and i commands with Unescape, so
+// it may use the same escapes as the -o and -s options, and \/ stands for the delimiter.
+func unescapeCommandText(text string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			if text[i+1] != '/' {
+				b.WriteByte('\\')
+			}
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+	return Unescape(b.String())
+}
+
+func extractRegexpCommandParameter(command string) (param string, err error) {
+	return extractCommandParameter(command, '/', '/')
+}
+
+func extractArraylikeCommandParameter(command string) (param string, err error) {
+	return extractCommandParameter(command, '[', ']')
+}
+
+func extractCommandParameter(command string, lmark, rmark rune) (param string, err error) {
+	// First char of the command is the command label, then it must be /.../
+	if len(command) < 3 {
+		err = fmt.Errorf("Command '%s' is malformatted", command)
+		return
+	}
+
+	if command[1] != byte(lmark) {
+		err = fmt.Errorf("Command '%c' must be followed by a forward slash (the complete command is: '%s')",
+			command[0], command)
+		return
+	}
+
+	if command[len(command)-1] != byte(rmark) {
+		err = fmt.Errorf("Command '%c' must be terminated by a forward slash (the complete command is: '%s')",
+			command[0], command)
+		return
+	}
+
+	param = command[2 : len(command)-1]
+	return
+}
+
+// extractCommandParameters extracts the `n` slash-delimited parameters of a command such as
+// s/pattern/replacement/, along with any flags that follow the last parameter. Escaped slashes
+// are left escaped in the parameters.
+func extractCommandParameters(command string, n int) (params []string, flags string, err error) {
+	runes := []rune(command)
+	if len(runes) < 2 || runes[1] != '/' {
+		err = fmt.Errorf("Command '%c' must be followed by a forward slash (the complete command is: '%s')",
+			runes[0], command)
+		return
+	}
+
+	var param bytes.Buffer
+	esc := false
+	i := 2
+	for ; i < len(runes) && len(params) < n; i++ {
+		r := runes[i]
+		switch {
+		case esc:
+			esc = false
+		case r == '\\':
+			esc = true
+		case r == '/':
+			params = append(params, param.String())
+			param.Reset()
+			continue
+		}
+		param.WriteRune(r)
+	}
+
+	if len(params) < n {
+		err = fmt.Errorf("Command '%c' must have %d parameters terminated by forward slashes (the complete command is: '%s')",
+			runes[0], n, command)
+		return
+	}
+
+	flags = string(runes[i:])
+	return
+}
@@ -0,0 +1,225 @@
+package srex
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Program is a compiled sequence of srex commands, such as `x/pattern/ g/pattern/ p`. A
+// Program may be run any number of times, but not concurrently.
+type Program struct {
+	src string
+
+	// NullLines makes line addresses, the = command and the line numbers output by JSONSink
+	// treat the input as lines that end with NUL bytes instead of newlines, like sed -z.
+	NullLines bool
+	// Runes makes the offsets printed by =# and the columns printed by =+ and JSONSink count
+	// runes, which are UTF-8 encoded characters, instead of bytes.
+	Runes bool
+	// Before and After are the numbers of records to print before and after each record that
+	// has output, like grep's -B and -A options. The records are the ranges of the first
+	// command, which must be x, y or z.
+	Before, After int
+}
+
+// Compile parses a program, returning an error if the commands aren't valid.
+func Compile(program string) (*Program, error) {
+	if _, err := parseCommands(program, nil); err != nil {
+		return nil, err
+	}
+	return &Program{src: program}, nil
+}
+
+// CompileScript is like Compile, but for a program read from a script file. The commands may be
+// spread over any number of lines, and a line whose first character other than spaces and tabs
+// is a # that isn't followed by a digit is a comment. So a script may start with a #! line, but
+// the # command can't start a line of its own, and an address such as #100 is never a comment.
+func CompileScript(script string) (*Program, error) {
+	p, err := Compile(stripComments(script))
+	if err != nil {
+		if n, line, ok := trailingComment(script); ok {
+			return nil, fmt.Errorf("Line %d looks like it ends with a comment, but comments must be on a line of their own: %s", n, line)
+		}
+	}
+	return p, err
+}
+
+// MustCompile is like Compile but panics if the program isn't valid.
+func MustCompile(program string) *Program {
+	p, err := Compile(program)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source text of the program.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Run runs the program on `input`, sending the output to `sink`. If `sink` is nil the output is
+// written to stdout. The length of the input is found using its Size method or by seeking to
+// its end, unless it is a Stream.
+func (p *Program) Run(input io.ReaderAt, sink Sink) error {
+	return p.RunContext(context.Background(), input, sink)
+}
+
+// RunContext is like Run, but stops the program if `ctx` is canceled.
+func (p *Program) RunContext(ctx context.Context, input io.ReaderAt, sink Sink) error {
+	_, err := p.Exec(ctx, input, sink)
+	return err
+}
+
+// Exec is like RunContext, but also returns the number of matches: the number of ranges that
+// were printed by the p, = and f commands, counted by k, or changed by the editing commands.
+func (p *Program) Exec(ctx context.Context, input io.ReaderAt, sink Sink) (matches int64, err error) {
+	if sink == nil {
+		sink = &WriterSink{}
+	}
+
+	var records *contextSink
+	if p.Before > 0 || p.After > 0 {
+		records = newContextSink(sink, p.Before, p.After)
+		sink = records
+	}
+
+	// The commands keep state while they run, so each run gets a new set.
+	cmds, err := parseCommands(p.src, sink)
+	if err != nil {
+		return
+	}
+	if p.NullLines {
+		useNullLines(cmds)
+	}
+	if records != nil {
+		if err = checkContext(cmds); err != nil {
+			return
+		}
+	}
+
+	ex := newExecutor(cmds)
+	ex.Sink = sink
+	ex.records = records
+	ex.nullLines = p.NullLines
+	ex.countRunes = p.Runes
+	err = ex.GoContext(ctx, input)
+	return ex.Matches(), err
+}
+
+// CheckStreamable returns an error if the program can't run on a Stream that doesn't end, such
+// as a file that is being followed. The first command must be able to start on the input before
+// all of it has been read, and there may be no commands that only output at the end of the input:
+// the editing commands, and commands such as n, # and o.
+func (p *Program) CheckStreamable() error {
+	cmds, err := parseCommands(p.src, nil)
+	if err != nil {
+		return err
+	}
+	return checkStreamable(cmds)
+}
+
+func checkStreamable(commands []command) error {
+	if len(commands) == 0 || !streamsInput(commands[0]) {
+		return fmt.Errorf("The commands must start with x, y or z to run on a stream that doesn't end")
+	}
+
+	err := commandsAllowStreaming(commands)
+	walkBlocks(commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			if err == nil {
+				err = commandsAllowStreaming(p)
+			}
+		}
+	})
+	return err
+}
+
+func commandsAllowStreaming(commands []command) error {
+	for _, c := range commands {
+		switch c.(type) {
+		case *blockCommand:
+			continue
+		case editor:
+			return fmt.Errorf("Editing commands can't be used on a stream that doesn't end")
+		case doner, *countCommand:
+			return fmt.Errorf("Commands that only output at the end of the input, such as n, #, o, r and k, can't be used on a stream that doesn't end")
+		}
+	}
+	return nil
+}
+
+// CheckEditsOnly returns an error unless the only output of the program is the edited input, as
+// is needed to write the output in place of the input. The program must contain editing commands,
+// and may not contain commands that print, such as p, =, f, # and k, including the p added to a
+// pipeline that doesn't end with a terminal command, or commands that select ranges by their
+// order, such as n, o, u and r.
+func (p *Program) CheckEditsOnly() error {
+	cmds, err := parseCommands(p.src, nil)
+	if err != nil {
+		return err
+	}
+	if !hasEditor(cmds) {
+		return fmt.Errorf("The program has no editing commands, such as s, c, a, i or d")
+	}
+
+	err = commandsOnlyEdit(cmds)
+	walkBlocks(cmds, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			if err == nil {
+				err = commandsOnlyEdit(p)
+			}
+		}
+	})
+	return err
+}
+
+func commandsOnlyEdit(commands []command) error {
+	printError := fmt.Errorf("Commands that print, such as p, =, f, # and k, can't be used with the edited input, including the p added to a pipeline without a terminal command")
+	if len(commands) == 0 || !isTerminal(commands[len(commands)-1]) {
+		return printError
+	}
+
+	for _, c := range commands {
+		switch c.(type) {
+		case *printCommand, *printLineCommand, *formatCommand, *countCommand, *aggregateCommand:
+			return printError
+		case *nCommand, *sortCommand, *uniqCommand, *reverseCommand:
+			return fmt.Errorf("Commands that select ranges by their order, such as n, o, u and r, can't be used with the edited input")
+		}
+	}
+	return nil
+}
+
+// hasEditor returns true if `commands` or the pipelines of any of their blocks contain an editor.
+func hasEditor(commands []command) bool {
+	found := containsEditor(commands)
+	walkBlocks(commands, func(b *blockCommand) {
+		for _, p := range b.pipelines {
+			found = found || containsEditor(p)
+		}
+	})
+	return found
+}
+
+func containsEditor(commands []command) bool {
+	for _, c := range commands {
+		if _, ok := c.(editor); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckContext returns an error if context can't be printed for the program's records, as
+// Before and After ask: the first command must be x, y or z, and the output for each record
+// must be made as the record is handled, so there may be no editing commands, or commands such
+// as n and o that hold on to ranges.
+func (p *Program) CheckContext() error {
+	cmds, err := parseCommands(p.src, nil)
+	if err != nil {
+		return err
+	}
+	return checkContext(cmds)
+}
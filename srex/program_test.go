@@ -0,0 +1,1283 @@
+package srex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// rangeSink is a Sink that records the ranges it is given.
+type rangeSink struct {
+	printed []Range
+	lines   [][2]int
+	counts  []int64
+	texts   []string
+
+	aggregates []Aggregate
+}
+
+func (s *rangeSink) Print(data io.ReaderAt, m Match) error {
+	s.printed = append(s.printed, m.Range)
+	return nil
+}
+
+func (s *rangeSink) PrintLocation(loc Location) error {
+	s.lines = append(s.lines, [2]int{loc.Line, loc.EndLine})
+	return nil
+}
+
+func (s *rangeSink) PrintText(m Match, text []byte) error {
+	s.texts = append(s.texts, string(text))
+	return nil
+}
+
+func (s *rangeSink) PrintCount(n int64) error {
+	s.counts = append(s.counts, n)
+	return nil
+}
+
+func (s *rangeSink) PrintAggregate(a Aggregate) error {
+	s.aggregates = append(s.aggregates, a)
+	return nil
+}
+
+func (s *rangeSink) Edited(data io.ReaderAt, length int64, edits *EditLog) error {
+	return nil
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		ok      bool
+	}{
+		{
+			name:    "x",
+			program: "x/a/",
+			ok:      true,
+		},
+		{
+			name:    "pipeline",
+			program: "x/a.*\n/ g/b/ { = p }",
+			ok:      true,
+		},
+		{
+			name:    "bad regexp",
+			program: "x/a(/",
+		},
+		{
+			name:    "unknown command",
+			program: "x/a/ q",
+		},
+		{
+			name:    "field",
+			program: "x/(?P<a>.)/ g:a/b/ v:1/c/",
+			ok:      true,
+		},
+		{
+			name:    "field of x",
+			program: "x:a/b/",
+		},
+		{
+			name:    "missing field",
+			program: "g:/b/",
+		},
+		{
+			name:    "invalid field",
+			program: "g:a-b/b/",
+		},
+		{
+			name:    "format",
+			program: "x/(?P<a>.)/ f/{a|upper} {text}/",
+			ok:      true,
+		},
+		{
+			name:    "compare",
+			program: `x/a/ w/(\d+)/ >= 5 w/b/ <"a b" { w/c/ !=1 }`,
+			ok:      true,
+		},
+		{
+			name:    "order",
+			program: `x/a/ o o/(\d+)/nr o//n u u/(\w+)/ { r }`,
+			ok:      true,
+		},
+		{
+			name:    "aggregate",
+			program: `x/a/ { k/(\w+)/ k:n/(\w+) (?P<n>\d+)/ }`,
+			ok:      true,
+		},
+		{
+			name:    "aggregate with invalid field",
+			program: "x/a/ k:/b/",
+		},
+		{
+			name:    "sort with bad flags",
+			program: "x/a/ o/b/nx",
+		},
+		{
+			name:    "uniq with flags",
+			program: "x/a/ u/b/n",
+		},
+		{
+			name:    "compare without comparison",
+			program: "x/a/ w/b/",
+		},
+		{
+			name:    "compare without value",
+			program: "x/a/ { w/b/ < }",
+		},
+		{
+			name:    "compare with unknown operator",
+			program: "x/a/ w/b/ =~ 5",
+		},
+		{
+			name:    "compare with bad quotes",
+			program: `x/a/ w/b/ == "a`,
+		},
+		{
+			name:    "word starting with d",
+			program: `x/.*\n/ delete`,
+		},
+		{
+			name:    "word starting with p",
+			program: `x/.*\n/ print`,
+		},
+		{
+			name:    "word starting with #",
+			program: `x/.*\n/ #lines`,
+		},
+		{
+			name:    "bad template",
+			program: "x/a/ f/{a/",
+		},
+		{
+			name:    "bad escape in template",
+			program: `x/a/ f/{text}\q/`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.program)
+			if tc.ok && err != nil {
+				t.Fatalf("Expected no error but got %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatalf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestCompileScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected string
+		err      string
+	}{
+		{
+			name:     "lines",
+			script:   "x/line\\d/\n  g/[23]/\n",
+			expected: "line2;line3",
+		},
+		{
+			name:     "comments",
+			script:   "#!/usr/bin/env -S srex -f\n# Each line\nx/line\\d/\n\t# that has a 2 or 3\n\tg/[23]/\n#\n",
+			expected: "line2;line3",
+		},
+		{
+			name:     "address",
+			script:   "#6,#17\n# The second and third lines\nx/line\\d/ g/[12]/\n",
+			expected: "line2",
+		},
+		{
+			name:   "comment after command",
+			script: "x/line\\d/\ng/[23]/   # only 2 and 3\n",
+			err:    "Line 2 looks like it ends with a comment, but comments must be on a line of their own: g/[23]/   # only 2 and 3",
+		},
+		{
+			name:   "comment after command that looks like a command",
+			script: "x/line\\d/   # print the lines\n",
+			err:    "Line 1 looks like it ends with a comment, but comments must be on a line of their own: x/line\\d/   # print the lines",
+		},
+		{
+			name:     "count followed by command",
+			script:   "x/line\\d/ # g/[23]/\n",
+			expected: "line2;line33\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prog, err := CompileScript(tc.script)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("Expected the error %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CompileScript failed: %v", err)
+			}
+
+			var out bytes.Buffer
+			if err := prog.Run(strings.NewReader("line1\nline2\nline3\n"), &WriterSink{Out: &out, Sep: ";"}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+// coreSink is a Sink that implements none of the optional interfaces.
+type coreSink struct {
+	printed int
+}
+
+func (s *coreSink) Print(data io.ReaderAt, m Match) error {
+	s.printed++
+	return nil
+}
+
+func (s *coreSink) PrintLocation(loc Location) error {
+	s.printed++
+	return nil
+}
+
+func (s *coreSink) Edited(data io.ReaderAt, length int64, edits *EditLog) error {
+	return nil
+}
+
+func TestProgramRunCoreSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		printed int
+		err     bool
+	}{
+		{name: "print", program: `x/line\d/ g/[12]/`, printed: 2},
+		{name: "line numbers", program: `x/line\d/ { p = }`, printed: 6},
+		{name: "format", program: `x/line\d/ f/{text}/`, err: true},
+		{name: "count", program: `x/line\d/ #`, err: true},
+		{name: "aggregate", program: `x/line\d/ k/\d/`, err: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var sink coreSink
+			err := MustCompile(tc.program).Run(strings.NewReader("line1\nline2\nline3\n"), &sink)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if sink.printed != tc.printed {
+				t.Fatalf("Expected %d ranges to be printed but got %d", tc.printed, sink.printed)
+			}
+		})
+	}
+}
+
+func TestProgramRun(t *testing.T) {
+	prog := MustCompile(`x/line\d\n/ g/[13]/`)
+	input := strings.NewReader("line1\nline2\nline3\n")
+
+	// A program can be run more than once.
+	for i := 0; i < 2; i++ {
+		var sink rangeSink
+		if err := prog.Run(input, &sink); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+
+		expected := []Range{{0, 6}, {12, 18}}
+		if len(sink.printed) != len(expected) || sink.printed[0] != expected[0] || sink.printed[1] != expected[1] {
+			t.Fatalf("Expected %v but got %v", expected, sink.printed)
+		}
+	}
+
+	var sink rangeSink
+	if err := MustCompile(`x/line\d\n/ g/[23]/ =`).Run(input, &sink); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(sink.lines) != 2 || sink.lines[0] != [2]int{2, 3} || sink.lines[1] != [2]int{3, 4} {
+		t.Fatalf("Unexpected lines %v", sink.lines)
+	}
+}
+
+func TestCountCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected []int64
+		matches  int64
+	}{
+		{
+			name:     "pipeline",
+			program:  `# x/line\d/ # g/[12]/ #`,
+			expected: []int64{1, 3, 2},
+			matches:  2,
+		},
+		{
+			name:     "address",
+			program:  `2,3 # x/line/ #`,
+			expected: []int64{1, 2},
+			matches:  2,
+		},
+		{
+			name:     "block",
+			program:  `x/line\d/ { g/1/ # = # }`,
+			expected: []int64{1, 3},
+			matches:  4,
+		},
+		{
+			name:     "terminal",
+			program:  `x/line\d/ g/4/ #`,
+			expected: []int64{0},
+			matches:  0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var sink rangeSink
+			n, err := MustCompile(tc.program).Exec(context.Background(), strings.NewReader("line1\nline2\nline3\n"), &sink)
+			if err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+
+			if fmt.Sprint(sink.counts) != fmt.Sprint(tc.expected) {
+				t.Fatalf("Expected counts %v but got %v", tc.expected, sink.counts)
+			}
+			if n != tc.matches {
+				t.Fatalf("Expected %d matches but got %d", tc.matches, n)
+			}
+		})
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		sink     WriterSink
+		expected string
+	}{
+		{
+			name:     "print",
+			program:  `x/line\d/`,
+			sink:     WriterSink{Sep: ";", Prefix: "f:"},
+			expected: "f:line1;f:line2",
+		},
+		{
+			name:     "lines",
+			program:  `x/line\d/ =`,
+			sink:     WriterSink{Name: "f"},
+			expected: "f:1\nf:2\n",
+		},
+		{
+			name:     "offsets",
+			program:  `x/line\d/ =#`,
+			sink:     WriterSink{Name: "f"},
+			expected: "f:#0,#5\nf:#6,#11\n",
+		},
+		{
+			name:     "empty offsets",
+			program:  `#3 =#`,
+			sink:     WriterSink{},
+			expected: "#3\n",
+		},
+		{
+			name:     "positions",
+			program:  `x/ne\d\n?/ =+`,
+			sink:     WriterSink{Name: "f"},
+			expected: "f:1:3-2:1\nf:2:3-2:6\n",
+		},
+		{
+			name:     "edit",
+			program:  `x/line\d/ s/line/LINE/`,
+			sink:     WriterSink{},
+			expected: "LINE1\nLINE2",
+		},
+		{
+			name:     "edit changed only",
+			program:  `x/line\d/ g/2/ s/line/LINE/`,
+			sink:     WriterSink{ChangedOnly: true},
+			expected: "LINE2",
+		},
+		{
+			name:     "highlight",
+			program:  `x/line\d/ g/\d/`,
+			sink:     WriterSink{Sep: ";", Highlight: true},
+			expected: "line\x1b[01;31m\x1b[K1\x1b[m\x1b[K;line\x1b[01;31m\x1b[K2\x1b[m\x1b[K",
+		},
+		{
+			name:     "highlight innermost",
+			program:  `x/line\d/ g/ne/ g/l/ v/3/`,
+			sink:     WriterSink{Highlight: true},
+			expected: "\x1b[01;31m\x1b[Kl\x1b[m\x1b[Kine1\x1b[01;31m\x1b[Kl\x1b[m\x1b[Kine2",
+		},
+		{
+			name:     "highlight outside range",
+			program:  `x/line\d/ g/ne/ y/n/`,
+			sink:     WriterSink{Sep: ";", Highlight: true},
+			expected: "li;\x1b[01;31m\x1b[Ke\x1b[m\x1b[K1;li;\x1b[01;31m\x1b[Ke\x1b[m\x1b[K2",
+		},
+		{
+			name:     "highlight x",
+			program:  `x/\d/`,
+			sink:     WriterSink{Highlight: true},
+			expected: "\x1b[01;31m\x1b[K1\x1b[m\x1b[K\x1b[01;31m\x1b[K2\x1b[m\x1b[K",
+		},
+		{
+			name:     "null framing",
+			program:  `x/line\d/`,
+			sink:     WriterSink{Sep: ";", Prefix: "f:", Framing: NullFraming},
+			expected: "f:line1\x00f:line2\x00",
+		},
+		{
+			name:     "netstring framing",
+			program:  `x/line\d\n?/`,
+			sink:     WriterSink{Framing: NetstringFraming},
+			expected: "6:line1\n,5:line2,",
+		},
+		{
+			name:     "lines null framing",
+			program:  `x/line\d/ =`,
+			sink:     WriterSink{Framing: NullFraming},
+			expected: "1\x002\x00",
+		},
+		{
+			name:     "lines netstring framing",
+			program:  `x/line\d/ =`,
+			sink:     WriterSink{Name: "f", Framing: NetstringFraming},
+			expected: "3:f:1,3:f:2,",
+		},
+		{
+			name:     "edit changed only null framing",
+			program:  `x/line\d/ s/line/LINE/`,
+			sink:     WriterSink{ChangedOnly: true, Framing: NullFraming},
+			expected: "LINE1\x00LINE2\x00",
+		},
+		{
+			name:     "edit null framing",
+			program:  `x/line\d/ g/2/ s/line/LINE/`,
+			sink:     WriterSink{Framing: NullFraming},
+			expected: "line1\nLINE2",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sink := tc.sink
+			sink.Out = &out
+
+			if err := MustCompile(tc.program).Run(strings.NewReader("line1\nline2"), &sink); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual '%s' does not match expected '%s'", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestRunes(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		runes    bool
+		expected string
+	}{
+		{
+			name:     "byte offsets",
+			program:  `x/w.r/ =#`,
+			expected: "#7,#11\n#20,#24\n",
+		},
+		{
+			name:     "rune offsets",
+			program:  `x/w.r/ =#`,
+			runes:    true,
+			expected: "#6,#9\n#18,#21\n",
+		},
+		{
+			name:     "byte columns",
+			program:  `x/w.r/ =+`,
+			expected: "1:8-1:12\n2:7-2:11\n",
+		},
+		{
+			name:     "rune columns",
+			program:  `x/w.r/ =+`,
+			runes:    true,
+			expected: "1:7-1:10\n2:7-2:10\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			prog := MustCompile(tc.program)
+			prog.Runes = tc.runes
+
+			if err := prog.Run(strings.NewReader("h\u00e9llo w\u00f6rld\nline2 w\u00f6rd\n"), &WriterSink{Out: &out}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestNullLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:     "lines",
+			program:  `x/b[^\x00]*/ =`,
+			expected: "2\n3\n",
+		},
+		{
+			name:     "address",
+			program:  `2`,
+			expected: "b\nb\x00",
+		},
+		{
+			name:     "address range",
+			program:  `2,3 =`,
+			expected: "2,4\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			prog := MustCompile(tc.program)
+			prog.NullLines = true
+
+			if err := prog.Run(strings.NewReader("a\na\x00b\nb\x00b\x00"), &WriterSink{Out: &out}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	tests := []struct {
+		name          string
+		program       string
+		before, after int
+		sink          WriterSink
+		expected      string
+	}{
+		{
+			name:     "before and after",
+			program:  `x/.*\n/ g/E/`,
+			before:   1,
+			after:    1,
+			expected: "r2\nr3E\nr4\n--\nr6\nr7E\nr8\n",
+		},
+		{
+			name:     "before",
+			program:  `x/.*\n/ g/E/`,
+			before:   2,
+			expected: "r1\nr2\nr3E\n--\nr5\nr6\nr7E\n",
+		},
+		{
+			name:     "after",
+			program:  `x/.*\n/ g/E/`,
+			after:    3,
+			expected: "r3E\nr4\nr5\nr6\nr7E\nr8\nr9\n",
+		},
+		{
+			name:     "start and end",
+			program:  `x/.*\n/ g/r[09]/`,
+			before:   2,
+			after:    2,
+			expected: "r0\nr1\nr2\n--\nr7E\nr8\nr9\n",
+		},
+		{
+			name:     "inner output",
+			program:  `x/.*\n/ g/E/ x/E/ =`,
+			before:   1,
+			expected: "r2\n4\n--\nr6\n8\n",
+		},
+		{
+			name:     "separator",
+			program:  `x/r\d/ g/[37]/`,
+			before:   1,
+			sink:     WriterSink{Sep: ","},
+			expected: "r2,r3\n--\nr6,r7",
+		},
+		{
+			name:     "null framing",
+			program:  `x/r\d/ g/[37]/`,
+			before:   1,
+			sink:     WriterSink{Framing: NullFraming},
+			expected: "r2\x00r3\x00--\x00r6\x00r7\x00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, streamed := range []bool{false, true} {
+				var out bytes.Buffer
+				sink := tc.sink
+				sink.Out = &out
+
+				prog := MustCompile(tc.program)
+				prog.Before, prog.After = tc.before, tc.after
+
+				var input io.ReaderAt = strings.NewReader("r0\nr1\nr2\nr3E\nr4\nr5\nr6\nr7E\nr8\nr9\n")
+				if streamed {
+					input = NewStream(input.(io.Reader))
+				}
+
+				n, err := prog.Exec(context.Background(), input, &sink)
+				if err != nil {
+					t.Fatalf("Exec failed: %v", err)
+				}
+				if n != 2 {
+					t.Fatalf("Expected 2 matches but got %d", n)
+				}
+
+				if out.String() != tc.expected {
+					t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+				}
+			}
+		})
+	}
+
+	for _, program := range []string{``, `g/a/`, `x/a/ d`, `x/a/ n[0]`} {
+		prog := MustCompile(program)
+		prog.Before = 1
+		if err := prog.Run(strings.NewReader("a"), &rangeSink{}); err == nil {
+			t.Fatalf("Expected an error for %s", program)
+		}
+		if err := prog.CheckContext(); err == nil {
+			t.Fatalf("Expected CheckContext to fail for %s", program)
+		}
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:    "print",
+			program: `x/line\d/ g/[13]/`,
+			expected: `{"file":"f","start":0,"end":5,"start_line":1,"end_line":1,"start_column":1,"end_column":6,"text":"line1","groups":[{"start":4,"end":5,"text":"1"}]}
+{"file":"f","start":12,"end":17,"start_line":3,"end_line":3,"start_column":1,"end_column":6,"text":"line3","groups":[{"start":16,"end":17,"text":"3"}]}
+`,
+		},
+		{
+			name:    "groups",
+			program: `x/(?P<word>[a-z]+)(\d)(x)?/ g/(?P<num>2)/`,
+			expected: `{"file":"f","start":6,"end":11,"start_line":2,"end_line":2,"start_column":1,"end_column":6,"text":"line2","groups":[{"start":10,"end":11,"text":"2"},{"start":10,"end":11,"text":"2"}],"named":{"num":{"start":10,"end":11,"text":"2"},"word":{"start":6,"end":10,"text":"line"}}}
+`,
+		},
+		{
+			name:    "unmatched group",
+			program: `x/line(\d)(x)?\n/ v/1/`,
+			expected: `{"file":"f","start":6,"end":12,"start_line":2,"end_line":3,"start_column":1,"end_column":1,"text":"line2\n","groups":[{"start":6,"end":12,"text":"line2\n"},{"start":10,"end":11,"text":"2"},null]}
+`,
+		},
+		{
+			name:    "lines",
+			program: `x/line\d\n?/ g/[23]/ =`,
+			expected: `{"file":"f","start":6,"end":12,"start_line":2,"end_line":3}
+{"file":"f","start":12,"end":17,"start_line":3,"end_line":3}
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sink := JSONSink{Out: &out, Name: "f"}
+
+			if err := MustCompile(tc.program).Run(strings.NewReader("line1\nline2\nline3"), &sink); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual '%s' does not match expected '%s'", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestFieldCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:     "g named",
+			program:  `x/.*\n/ g/(?P<opc>\w+)=/ g:opc/b/`,
+			expected: "b=ab\n",
+		},
+		{
+			name:     "g numbered",
+			program:  `x/.*\n/ g/(\w+)=(\w+)/ g:2/b/`,
+			expected: "a=b\nb=ab\n",
+		},
+		{
+			name:     "v named",
+			program:  `x/.*\n/ g/(?P<opc>\w+)=/ v:opc/b/`,
+			expected: "a=b\nc=\n",
+		},
+		{
+			name:     "earlier regexp",
+			program:  `x/(?P<opc>\w+)=.*\n/ g/=/ g:opc/[bc]/`,
+			expected: "b=ab\nc=\n",
+		},
+		{
+			name:     "missing group",
+			program:  `x/.*\n/ g/=(?P<val>\w+)?/ v:val/b/`,
+			expected: "c=\n",
+		},
+		{
+			name:     "no group",
+			program:  `x/.*\n/ g:opc/a/`,
+			expected: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := MustCompile(tc.program).Run(strings.NewReader("a=b\nb=ab\nc=\n"), &WriterSink{Out: &out}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestTemplateSink(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		template string
+		sink     WriterSink
+		expected string
+	}{
+		{
+			name:     "fields",
+			program:  `x/line\d\n?/ g/[23]/`,
+			template: "{file}:{line} {start}-{end} {text}",
+			expected: "f:2 6-12 line2\n\nf:3 12-17 line3\n",
+		},
+		{
+			name:     "groups",
+			program:  `x/(?P<word>[a-z]+)(\d)(x)?/ g/(?P<num>[13])/`,
+			template: "{word}/{num}/{1}/{3}/{{}}",
+			expected: "line/1/1//{}\nline/3/3//{}\n",
+		},
+		{
+			name:     "null framing",
+			program:  `x/line\d/ g/[12]/`,
+			template: "{text}",
+			sink:     WriterSink{Framing: NullFraming},
+			expected: "line1\x00line2\x00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sink := TemplateSink{WriterSink: tc.sink, Template: MustParseTemplate(tc.template)}
+			sink.Out, sink.File = &out, "f"
+
+			if err := MustCompile(tc.program).Run(strings.NewReader("line1\nline2\nline3"), &sink); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestFormatCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+		matches  int64
+	}{
+		{
+			name:     "fields",
+			program:  `x/line\d\n?/ g/[23]/ f/{index} {file}:{line},{end_line} {start}-{end}/`,
+			expected: "0 f:2,3 6-12\n1 f:3,3 12-17\n",
+			matches:  2,
+		},
+		{
+			name:     "groups",
+			program:  `x/(?P<word>[a-z]+)(\d)/ g/[13]/ f/{word|upper}-{0}{1}{{}}/`,
+			expected: "LINE-1{}\nLINE-3{}\n",
+			matches:  2,
+		},
+		{
+			name:     "filters",
+			program:  `x/line1\nline2/ f/{text|quote} {text|oneline|lower|quote}/`,
+			expected: "\"line1\\nline2\" \"line1 line2\"\n",
+			matches:  1,
+		},
+		{
+			name:     "trim",
+			program:  `x/ ?line\d\n/ f/[{text|trim}]/`,
+			expected: "[line1]\n[line2]\n",
+			matches:  2,
+		},
+		{
+			name:     "escapes",
+			program:  `x/line3/ f/a\/b\nc/`,
+			expected: "a/b\nc\n",
+			matches:  1,
+		},
+		{
+			name:     "tab and hex escapes",
+			program:  `x/(line)(3)/ f/{1}\t{2}\x21\\/`,
+			expected: "line\t3!\\\n",
+			matches:  1,
+		},
+		{
+			name:     "block",
+			program:  `x/line\d/ { g/1/ f/one/ g/2/ f/two/ }`,
+			expected: "one\ntwo\n",
+			matches:  2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			matches, err := MustCompile(tc.program).Exec(context.Background(), strings.NewReader("line1\nline2\nline3"), &WriterSink{Out: &out, File: "f"})
+			if err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+			if matches != tc.matches {
+				t.Fatalf("Expected %d matches but got %d", tc.matches, matches)
+			}
+		})
+	}
+}
+
+func TestCompareCommand(t *testing.T) {
+	input := `1) 2023-10-01 09:07:59 Event: LINK length:10 name:bob
+2) 2023-10-01 09:08:00.5 Event: PORT length:60 name:alice
+3) 2023-10-02 08:00:00 Event: LINK length:9 name:carol
+`
+
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:     "numeric",
+			program:  `x/.*\n/ w/length:(\d+)/ > 9`,
+			expected: "1,2",
+		},
+		{
+			name:     "numeric not lexical",
+			program:  `x/.*\n/ w/length:(\d+)/ < 10.5`,
+			expected: "1,3",
+		},
+		{
+			name:     "equal",
+			program:  `x/.*\n/ w/length:(\d+)/ ==60`,
+			expected: "2",
+		},
+		{
+			name:     "not equal",
+			program:  `x/.*\n/ w/length:(\d+)/ != 60`,
+			expected: "1,3",
+		},
+		{
+			name:     "time of day",
+			program:  `x/.*\n/ w/\d+:\d+:[\d.]+/ >= 09:08:00`,
+			expected: "2",
+		},
+		{
+			name:     "date and time",
+			program:  `x/.*\n/ w/\) (\S+ \S+)/ > "2023-10-01 09:08:00"`,
+			expected: "2,3",
+		},
+		{
+			name:     "date",
+			program:  `x/.*\n/ w/\) (\S+)/ <= 2023-10-01`,
+			expected: "1,2",
+		},
+		{
+			name:     "lexical",
+			program:  `x/.*\n/ w/name:(\w+)/ < c`,
+			expected: "1,2",
+		},
+		{
+			name:     "not a number",
+			program:  `x/.*\n/ w/name:(\w+)/ < 5`,
+			expected: "",
+		},
+		{
+			name:     "group",
+			program:  `x/.*\n/ w/Event: (?P<ev>\w+)/ == LINK`,
+			expected: "1,3",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var sink rangeSink
+			if err := MustCompile(tc.program).Run(strings.NewReader(input), &sink); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			var records []string
+			for _, r := range sink.printed {
+				records = append(records, input[r.Start:r.Start+1])
+			}
+			if actual := strings.Join(records, ","); actual != tc.expected {
+				t.Fatalf("Actual records %q do not match expected %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestOrderCommands(t *testing.T) {
+	input := "id=3 len=10\nid=1 len=9\nid=2 len=10\nid=1 len=100\nnone\n"
+
+	tests := []struct {
+		name     string
+		program  string
+		expected string
+	}{
+		{
+			name:     "sort",
+			program:  `x/.*\n/ o`,
+			expected: "id=1 len=100\nid=1 len=9\nid=2 len=10\nid=3 len=10\nnone\n",
+		},
+		{
+			name:     "sort by group",
+			program:  `x/.*\n/ o/len=(\d+)/`,
+			expected: "none\nid=3 len=10\nid=2 len=10\nid=1 len=100\nid=1 len=9\n",
+		},
+		{
+			name:     "sort numerically",
+			program:  `x/.*\n/ o/len=(\d+)/n`,
+			expected: "none\nid=1 len=9\nid=3 len=10\nid=2 len=10\nid=1 len=100\n",
+		},
+		{
+			name:     "sort numerically in reverse",
+			program:  `x/.*\n/ o/len=(\d+)/nr`,
+			expected: "id=1 len=100\nid=3 len=10\nid=2 len=10\nid=1 len=9\nnone\n",
+		},
+		{
+			name:     "sort text numerically",
+			program:  `x/\d+/ o//n`,
+			expected: "1;1;2;3;9;10;10;100",
+		},
+		{
+			name:     "sort then format",
+			program:  `x/.*\n/ o/id=(?P<id>\d+)/ f/{id}/`,
+			expected: "\n1\n1\n2\n3\n",
+		},
+		{
+			name:     "uniq",
+			program:  `x/\d+/ u`,
+			expected: "3;10;1;9;2;100",
+		},
+		{
+			name:     "uniq by key",
+			program:  `x/.*\n/ u/id=(\d+)/`,
+			expected: "id=3 len=10\nid=1 len=9\nid=2 len=10\nnone\n",
+		},
+		{
+			name:     "reverse",
+			program:  `x/.*\n/ g/id/ r`,
+			expected: "id=1 len=100\nid=2 len=10\nid=1 len=9\nid=3 len=10\n",
+		},
+		{
+			name:     "in a block",
+			program:  `x/.*\n/ { g/len=10\n/ r p v/id/ }`,
+			expected: "none\nid=2 len=10\nid=3 len=10\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sep := ""
+			if !strings.Contains(tc.expected, "\n") {
+				sep = ";"
+			}
+			if err := MustCompile(tc.program).Run(strings.NewReader(input), &WriterSink{Out: &out, Sep: sep}); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestAggregateCommand(t *testing.T) {
+	input := "1) LINK len=10\n2) PORT len=60\n3) LINK len=5.5\n4) LINK len=x\n5) STP\n6) none\n"
+
+	tests := []struct {
+		name     string
+		program  string
+		sink     Sink
+		expected string
+		matches  int64
+	}{
+		{
+			name:     "count",
+			program:  `x/.*\n/ k/\) ([A-Z]+)/`,
+			expected: "3\tLINK\n1\tPORT\n1\tSTP\n",
+			matches:  5,
+		},
+		{
+			name:     "whole match",
+			program:  `x/.*\n/ k/[A-Z]+/`,
+			expected: "3\tLINK\n1\tPORT\n1\tSTP\n",
+			matches:  5,
+		},
+		{
+			name:     "numbers",
+			program:  `x/.*\n/ k:len/([A-Z]+)( len=(?P<len>\S+))?/`,
+			expected: "3\tLINK\tmin=5.5\tmax=10\tsum=15.5\n1\tPORT\tmin=60\tmax=60\tsum=60\n1\tSTP\n",
+			matches:  5,
+		},
+		{
+			name:     "earlier group",
+			program:  `x/.*len=(?P<len>[\d.]+).*\n/ k:len/[A-Z]+/`,
+			sink:     &WriterSink{Name: "f"},
+			expected: "f:2\tLINK\tmin=5.5\tmax=10\tsum=15.5\nf:1\tPORT\tmin=60\tmax=60\tsum=60\n",
+			matches:  3,
+		},
+		{
+			name:    "json",
+			program: `x/.*\n/ k:1/len=([\d.]+)/`,
+			sink:    &JSONSink{Name: "f"},
+			expected: `{"file":"f","key":"10","count":1,"min":10,"max":10,"sum":10}
+{"file":"f","key":"60","count":1,"min":60,"max":60,"sum":60}
+{"file":"f","key":"5.5","count":1,"min":5.5,"max":5.5,"sum":5.5}
+`,
+			matches: 3,
+		},
+		{
+			name:     "in a block",
+			program:  `x/.*\n/ { k/LINK|PORT/ g/STP/ }`,
+			expected: "5) STP\n3\tLINK\n1\tPORT\n",
+			matches:  5,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sink := tc.sink
+			switch s := sink.(type) {
+			case nil:
+				sink = &WriterSink{Out: &out}
+			case *WriterSink:
+				s.Out = &out
+			case *JSONSink:
+				s.Out = &out
+			}
+
+			matches, err := MustCompile(tc.program).Exec(context.Background(), strings.NewReader(input), sink)
+			if err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Actual %q does not match expected %q", out.String(), tc.expected)
+			}
+			if matches != tc.matches {
+				t.Fatalf("Expected %d matches but got %d", tc.matches, matches)
+			}
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, s := range []string{"{", "a}", "{a b}", "{}", "{{a}", "{a|}", "{a|upper|x}"} {
+		if _, err := ParseTemplate(s); err == nil {
+			t.Fatalf("Expected an error for template %q", s)
+		}
+	}
+}
+
+func TestCheckStreamable(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		ok      bool
+	}{
+		{
+			name:    "x",
+			program: "x/a/ g/b/",
+			ok:      true,
+		},
+		{
+			name:    "g first",
+			program: "g/a/ x/b/",
+		},
+		{
+			name:    "address first",
+			program: "1,2 x/a/",
+		},
+		{
+			name:    "edit",
+			program: "x/a/ d",
+		},
+		{
+			name:    "edit in block",
+			program: "x/a/ { g/b/ p d }",
+		},
+		{
+			name:    "n",
+			program: "x/a/ n[1]",
+		},
+		{
+			name:    "count",
+			program: "x/a/ #",
+		},
+		{
+			name:    "n in block",
+			program: "x/a/ { g/b/ p n[0] }",
+		},
+		{
+			name:    "block",
+			program: "x/a/ { g/b/ p v/b/ = }",
+			ok:      true,
+		},
+		{
+			name:    "sort",
+			program: "x/a/ o",
+		},
+		{
+			name:    "reverse",
+			program: "x/a/ r",
+		},
+		{
+			name:    "sort in block",
+			program: "x/a/ { g/b/ o/(c)/n p }",
+		},
+		{
+			name:    "uniq",
+			program: "x/a/ u/b/",
+			ok:      true,
+		},
+		{
+			name:    "aggregate",
+			program: "x/a/ k/b/",
+		},
+		{
+			name:    "aggregate field in block",
+			program: "x/a/ { g/b/ p k:len/(\\d+)/ }",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := MustCompile(tc.program).CheckStreamable()
+			if tc.ok && err != nil {
+				t.Fatalf("Expected no error but got %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Fatalf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestProgramExecMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		expected int64
+	}{
+		{
+			name:     "print",
+			program:  `x/line\d/`,
+			expected: 3,
+		},
+		{
+			name:     "no match",
+			program:  `x/line\d/ g/4/`,
+			expected: 0,
+		},
+		{
+			name:     "lines",
+			program:  `x/line\d/ g/[12]/ =`,
+			expected: 2,
+		},
+		{
+			name:     "block",
+			program:  `x/line\d/ { g/1/ p g/[23]/ = }`,
+			expected: 3,
+		},
+		{
+			name:     "substitute",
+			program:  `x/line\d/ s/2/two/`,
+			expected: 1,
+		},
+		{
+			name:     "delete",
+			program:  `x/line\d\n/ v/2/ d`,
+			expected: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var sink rangeSink
+			n, err := MustCompile(tc.program).Exec(context.Background(), strings.NewReader("line1\nline2\nline3\n"), &sink)
+			if err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+
+			if n != tc.expected {
+				t.Fatalf("Expected %d matches but got %d", tc.expected, n)
+			}
+		})
+	}
+}
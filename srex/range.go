@@ -0,0 +1,51 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+)
+
+func lengthOfReaderAt(r io.ReaderAt) (int64, error) {
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		return s.Size(), nil
+	}
+	if s, ok := r.(io.Seeker); ok {
+		return s.Seek(0, io.SeekEnd)
+	}
+
+	return 0, fmt.Errorf("Can't determine length of ReaderAt since it is not a Seeker")
+}
+
+// Range is the part of the input from the byte offset Start up to, but not including, End.
+type Range struct {
+	Start, End int64
+}
+
+func emptyRange(start, end int64) bool {
+	return end-start <= 0
+}
+
+// newSectionReader returns a reader for the part of `data` between `start` and `end`. When
+// `data` is a stream the reader returns data as soon as it arrives, rather than waiting for
+// enough to fill the buffer it is reading into.
+func newSectionReader(data io.ReaderAt, start, end int64) io.Reader {
+	if s, ok := data.(streamInput); ok {
+		return s.sectionReader(start, end)
+	}
+	return io.NewSectionReader(data, start, end-start)
+}
+
+var completeRange = Range{-1, -1}
+
+func readRange(data io.ReaderAt, start, end int64) (buf []byte, err error) {
+	if end < start {
+		panic(fmt.Sprintf("readRange: can't read range %d-%d", start, end))
+	}
+	buf = make([]byte, end-start)
+	_, err = data.ReadAt(buf, start)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return
+}
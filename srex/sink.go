@@ -0,0 +1,336 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Sink receives the output of a Program. Its methods are never called at the same time, but
+// they may be called from a goroutine other than the one that called Run.
+//
+// Sink holds only the output that every sink must handle. The output of other commands, such as
+// f, # and k, is given to a sink through the optional interfaces TextPrinter, CountPrinter and
+// AggregatePrinter, and output added in future will be too, so that this interface doesn't
+// change.
+type Sink interface {
+	// Print is called for each range printed by the p command, including the p command that
+	// is added to the end of the commands when they don't end with a terminal command. `m`
+	// holds the submatches of the regexps that selected the range as well as the range.
+	Print(data io.ReaderAt, m Match) error
+	// PrintLocation is called for each range printed by the =, =# and =+ commands, with where
+	// the range is in the input.
+	PrintLocation(loc Location) error
+	// Edited is called once all of the input has been read, if the program contains editing
+	// commands. `length` is the length of the input and `edits` holds the changes to it.
+	Edited(data io.ReaderAt, length int64, edits *EditLog) error
+}
+
+// TextPrinter is implemented by sinks that print the text of the f command. A program with an f
+// command fails if its sink doesn't implement it.
+type TextPrinter interface {
+	// PrintText is called for each range formatted by the f command, with the text of its
+	// template for the range.
+	PrintText(m Match, text []byte) error
+}
+
+// CountPrinter is implemented by sinks that print the counts of the # command. A program with a
+// # command fails if its sink doesn't implement it.
+type CountPrinter interface {
+	// PrintCount is called for each # command once all of the input has been handled, with the
+	// number of ranges that passed through it.
+	PrintCount(n int64) error
+}
+
+// AggregatePrinter is implemented by sinks that print the aggregates of the k command. A program
+// with a k command fails if its sink doesn't implement it.
+type AggregatePrinter interface {
+	// PrintAggregate is called by each k command once all of the input has been read, with the
+	// aggregate of the ranges with each key.
+	PrintAggregate(a Aggregate) error
+}
+
+func printText(sink Sink, m Match, text []byte) error {
+	p, ok := sink.(TextPrinter)
+	if !ok {
+		return fmt.Errorf("The output of the f command can't be printed: the sink isn't a TextPrinter")
+	}
+	return p.PrintText(m, text)
+}
+
+func printCount(sink Sink, n int64) error {
+	p, ok := sink.(CountPrinter)
+	if !ok {
+		return fmt.Errorf("The output of the # command can't be printed: the sink isn't a CountPrinter")
+	}
+	return p.PrintCount(n)
+}
+
+func printAggregate(sink Sink, a Aggregate) error {
+	p, ok := sink.(AggregatePrinter)
+	if !ok {
+		return fmt.Errorf("The output of the k command can't be printed: the sink isn't an AggregatePrinter")
+	}
+	return p.PrintAggregate(a)
+}
+
+// LocationFormat is the form of the location printed by a form of the = command.
+type LocationFormat int
+
+const (
+	// LineFormat is the lines of the range, printed by =: 12, or 12,14 for more than one line.
+	LineFormat LocationFormat = iota
+	// OffsetFormat is the offsets of the range, printed by =# as sam does: #100, or #100,#120
+	// for a range that isn't empty.
+	OffsetFormat
+	// PositionFormat is the lines and columns of the start and end of the range, printed by =+:
+	// 12:5-14:3.
+	PositionFormat
+)
+
+// Location is where a range printed by the = command is in the input. The offsets and columns
+// count bytes, or runes if the Program counts runes.
+type Location struct {
+	// Range is the range, as byte offsets in the input.
+	Range  Range
+	Format LocationFormat
+	// Start and End are the offsets of the start and end of the range.
+	Start, End int64
+	// Line and EndLine are the numbers of the lines that contain the start and end of the
+	// range, and Col and EndCol the columns of the start and end in those lines. They are
+	// numbered from 1. As in sam, the end of a range that ends with a newline is at the start
+	// of the next line.
+	Line, EndLine int
+	Col, EndCol   int
+}
+
+// Framing is how a WriterSink marks the end of each range it prints, so that a program reading
+// the output can split it into the ranges even when they contain newlines.
+type Framing int
+
+const (
+	// NoFraming prints the ranges as they are, with the separator between them.
+	NoFraming Framing = iota
+	// NullFraming follows each range with a NUL byte, like find -print0.
+	NullFraming
+	// NetstringFraming prints each range as a netstring: the length of the range in decimal, a
+	// colon, the range and a comma.
+	NetstringFraming
+)
+
+// WriterSink is a Sink that writes the output to a Writer the way the srex command prints it.
+type WriterSink struct {
+	// Out is where the output is written. If it's nil the output is written to stdout.
+	Out io.Writer
+	// Sep is printed between the ranges printed by p, and between the changed regions when
+	// ChangedOnly is set. It isn't used if Framing is set.
+	Sep string
+	// Prefix, such as the file name, is printed before each range printed by p.
+	Prefix string
+	// Name is the name of the input printed by = before the line numbers. If it's empty only
+	// the line numbers are printed.
+	Name string
+	// File is the name of the input that the {file} placeholder of a template is replaced by.
+	File string
+	// ChangedOnly makes an editing program output only the changed regions instead of the
+	// whole edited input.
+	ChangedOnly bool
+	// Framing marks the end of each range printed by p and each line printed by =. With
+	// NullFraming the changed regions are also followed by NUL bytes; edits can't be output
+	// as netstrings.
+	Framing Framing
+	// Highlight marks the part of each range printed by p that was matched by the innermost
+	// regexp, the last regexp command that matched it such as the g in x/record/ g/ERROR/, with
+	// the escape sequences that grep --color uses.
+	Highlight bool
+
+	printSep bool
+	// endsLine is true if the output so far ends with a newline.
+	endsLine bool
+}
+
+func (s *WriterSink) out() io.Writer {
+	if s.Out == nil {
+		return os.Stdout
+	}
+	return s.Out
+}
+
+func (s *WriterSink) Print(data io.ReaderAt, m Match) error {
+	buf, err := readRange(data, m.Start, m.End)
+	if err != nil {
+		return err
+	}
+
+	if s.Highlight {
+		buf = highlight(buf, m)
+	}
+
+	if s.Framing != NoFraming {
+		return s.writeFramed(append([]byte(s.Prefix), buf...))
+	}
+
+	out := s.out()
+	if s.printSep && len(s.Sep) > 0 {
+		if _, err := io.WriteString(out, s.Sep); err != nil {
+			return err
+		}
+		s.noteEnd(s.Sep)
+	}
+	s.printSep = true
+
+	if _, err := io.WriteString(out, s.Prefix); err != nil {
+		return err
+	}
+	s.noteEnd(s.Prefix)
+	_, err = out.Write(buf)
+	s.noteEnd(string(buf))
+	return err
+}
+
+// noteEnd records whether the output ends with a newline once `text` has been written.
+func (s *WriterSink) noteEnd(text string) {
+	if len(text) > 0 {
+		s.endsLine = text[len(text)-1] == '\n'
+	}
+}
+
+// SeparateGroups prints -- on a line of its own between the groups of records printed with
+// context. The separator isn't printed before the record that follows it.
+func (s *WriterSink) SeparateGroups() error {
+	if s.Framing != NoFraming {
+		return s.writeFramed([]byte("--"))
+	}
+
+	text := "--\n"
+	if !s.endsLine {
+		text = "\n" + text
+	}
+	s.printSep = false
+	s.endsLine = true
+	_, err := io.WriteString(s.out(), text)
+	return err
+}
+
+// The escape sequences that start and end a highlighted match, which are the ones grep uses.
+const (
+	highlightStart = "\x1b[01;31m\x1b[K"
+	highlightEnd   = "\x1b[m\x1b[K"
+)
+
+// highlight returns the text `buf` of the range `m` with the part of it matched by the last
+// regexp command highlighted.
+func highlight(buf []byte, m Match) []byte {
+	if m.Groups == nil {
+		return buf
+	}
+	r, ok := m.Groups.Group(0)
+	if !ok {
+		return buf
+	}
+	if r.Start < m.Start {
+		r.Start = m.Start
+	}
+	if r.End > m.End {
+		r.End = m.End
+	}
+	if r.Start >= r.End {
+		return buf
+	}
+
+	start, end := r.Start-m.Start, r.End-m.Start
+	result := make([]byte, 0, len(buf)+len(highlightStart)+len(highlightEnd))
+	result = append(result, buf[:start]...)
+	result = append(result, highlightStart...)
+	result = append(result, buf[start:end]...)
+	result = append(result, highlightEnd...)
+	return append(result, buf[end:]...)
+}
+
+// writeFramed writes `buf` followed or surrounded by the framing.
+func (s *WriterSink) writeFramed(buf []byte) error {
+	var err error
+	switch s.Framing {
+	case NullFraming:
+		_, err = s.out().Write(append(buf, 0))
+	case NetstringFraming:
+		_, err = fmt.Fprintf(s.out(), "%d:%s,", len(buf), buf)
+	default:
+		_, err = s.out().Write(append(buf, '\n'))
+		s.endsLine = true
+	}
+	return err
+}
+
+func (s *WriterSink) PrintLocation(loc Location) error {
+	var text string
+	if s.Name != "" {
+		text = s.Name + ":"
+	}
+
+	switch loc.Format {
+	case OffsetFormat:
+		text += fmt.Sprintf("#%d", loc.Start)
+		if loc.End != loc.Start {
+			text += fmt.Sprintf(",#%d", loc.End)
+		}
+	case PositionFormat:
+		text += fmt.Sprintf("%d:%d-%d:%d", loc.Line, loc.Col, loc.EndLine, loc.EndCol)
+	default:
+		text += fmt.Sprintf("%d", loc.Line)
+		if loc.EndLine != loc.Line {
+			text += fmt.Sprintf(",%d", loc.EndLine)
+		}
+	}
+
+	return s.writeFramed([]byte(text))
+}
+
+// PrintText prints the text the way = prints the line numbers: followed by a newline, or framed
+// by Framing. Prefix, Sep and Highlight aren't used.
+func (s *WriterSink) PrintText(m Match, text []byte) error {
+	return s.writeFramed(text)
+}
+
+func (s *WriterSink) PrintCount(n int64) error {
+	var text string
+	if s.Name != "" {
+		text = s.Name + ":"
+	}
+	return s.writeFramed([]byte(text + strconv.FormatInt(n, 10)))
+}
+
+// PrintAggregate prints the count and the key separated by a tab, like uniq -c, followed by the
+// least, greatest and sum of the numbers in the field if there are any.
+func (s *WriterSink) PrintAggregate(a Aggregate) error {
+	var text string
+	if s.Name != "" {
+		text = s.Name + ":"
+	}
+	text += strconv.FormatInt(a.Count, 10) + "\t" + a.Key
+	if a.Values > 0 {
+		text += fmt.Sprintf("\tmin=%s\tmax=%s\tsum=%s", formatNumber(a.Min), formatNumber(a.Max), formatNumber(a.Sum))
+	}
+	return s.writeFramed([]byte(text))
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func (s *WriterSink) Edited(data io.ReaderAt, length int64, edits *EditLog) error {
+	switch s.Framing {
+	case NullFraming:
+		if err := edits.Apply(data, length, s.out(), s.ChangedOnly, "\x00"); err != nil {
+			return err
+		}
+		if s.ChangedOnly && edits.changeCount() > 0 {
+			return s.writeFramed(nil)
+		}
+		return nil
+	case NetstringFraming:
+		return fmt.Errorf("The edited input can't be output as netstrings")
+	}
+	return edits.Apply(data, length, s.out(), s.ChangedOnly, s.Sep)
+}
@@ -0,0 +1,285 @@
+package srex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// streamChunkSize is the size of the chunks a Stream stores its data in.
+	streamChunkSize = 64 * 1024
+	// streamMaxMemory is the amount of unreleased data a Stream keeps in memory before
+	// spilling the oldest data to a temporary file.
+	streamMaxMemory = 64 * 1024 * 1024
+)
+
+// streamInput is an input whose length isn't known until it has all been read, such as stdin.
+// Reads block until the data they ask for has arrived.
+type streamInput interface {
+	io.ReaderAt
+	// Size waits until the whole stream has been read and returns its length.
+	Size() (int64, error)
+	// release tells the stream that the data before `off` won't be read again.
+	release(off int64)
+	// sectionReader returns a reader for the data between `start` and `end`. Unlike an
+	// io.SectionReader its reads return as soon as some data is available.
+	sectionReader(start, end int64) io.Reader
+}
+
+// Stream is an input, such as stdin, that is read from an io.Reader as the data is needed.
+// A Program run on a Stream starts on the data as it arrives, and when it can, discards the data
+// it is done with. If too much data accumulates in memory the oldest chunks are spilled to a
+// temporary file.
+type Stream struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	src  io.Reader
+
+	// chunks holds the data from chunk number `base` onward. A nil chunk has been spilled.
+	chunks [][]byte
+	base   int64
+	size   int64
+	// released is the offset before which the data has been released.
+	released int64
+	filling  bool
+	eof      bool
+	err      error
+
+	chunkSize int64
+	maxMemory int64
+	memory    int64
+	spill     *os.File
+}
+
+// NewStream returns a new Stream that reads from `src`. Close must be called once the Stream is
+// no longer needed.
+func NewStream(src io.Reader) *Stream {
+	b := &Stream{
+		src:       src,
+		chunkSize: streamChunkSize,
+		maxMemory: streamMaxMemory,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// ReadAt reads len(p) bytes at `off`, waiting for them to arrive if needed.
+func (b *Stream) ReadAt(p []byte, off int64) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = b.waitFor(off + int64(len(p))); err != nil {
+		return
+	}
+
+	n, err = b.copyAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// readAvailableAt reads up to len(p) bytes at `off`, waiting only until at least one byte is available.
+func (b *Stream) readAvailableAt(p []byte, off int64) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = b.waitFor(off + 1); err != nil {
+		return
+	}
+
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	if max := b.size - off; int64(len(p)) > max {
+		p = p[:max]
+	}
+	return b.copyAt(p, off)
+}
+
+func (b *Stream) sectionReader(start, end int64) io.Reader {
+	return &streamSectionReader{b: b, off: start, end: end}
+}
+
+// Size waits until the whole stream has been read and returns its length.
+func (b *Stream) Size() (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !b.eof {
+		if err := b.fill(); err != nil {
+			return b.size, err
+		}
+	}
+	return b.size, b.err
+}
+
+func (b *Stream) release(off int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if off <= b.released {
+		return
+	}
+	b.released = off
+
+	for len(b.chunks) > 1 && (b.base+1)*b.chunkSize <= off {
+		b.memory -= int64(len(b.chunks[0]))
+		b.chunks[0] = nil
+		b.chunks = b.chunks[1:]
+		b.base++
+	}
+}
+
+// Close removes the spill file, if one was needed.
+func (b *Stream) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill == nil {
+		return nil
+	}
+	b.spill.Close()
+	return os.Remove(b.spill.Name())
+}
+
+// waitFor reads from the source until the stream has `end` bytes or is at EOF. It must be called with the lock held.
+func (b *Stream) waitFor(end int64) error {
+	for b.size < end && !b.eof {
+		if err := b.fill(); err != nil {
+			return err
+		}
+	}
+	if b.err != nil && end > b.size {
+		return b.err
+	}
+	return nil
+}
+
+// fill reads once from the source, or if another goroutine is already reading waits for it
+// to finish. The lock is released while reading so that data already in the buffer can be
+// read in the meantime.
+func (b *Stream) fill() error {
+	if b.filling {
+		b.cond.Wait()
+		return nil
+	}
+
+	b.filling = true
+	buf := make([]byte, b.chunkSize)
+	b.mu.Unlock()
+	n, err := b.src.Read(buf)
+	b.mu.Lock()
+	b.filling = false
+	defer b.cond.Broadcast()
+
+	if aerr := b.append(buf[:n]); aerr != nil {
+		return aerr
+	}
+
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil {
+		b.eof = true
+		b.err = err
+	}
+	return nil
+}
+
+func (b *Stream) append(data []byte) error {
+	for len(data) > 0 {
+		last := len(b.chunks) - 1
+		if last < 0 || int64(len(b.chunks[last])) == b.chunkSize {
+			b.chunks = append(b.chunks, make([]byte, 0, b.chunkSize))
+			last++
+		}
+
+		n := b.chunkSize - int64(len(b.chunks[last]))
+		if n > int64(len(data)) {
+			n = int64(len(data))
+		}
+		b.chunks[last] = append(b.chunks[last], data[:n]...)
+		data = data[n:]
+		b.size += n
+		b.memory += n
+	}
+
+	return b.spillIfNeeded()
+}
+
+// spillIfNeeded writes the oldest full chunks to the spill file until the data in memory is under the limit.
+func (b *Stream) spillIfNeeded() error {
+	for i := 0; b.memory > b.maxMemory && i < len(b.chunks)-1; i++ {
+		if b.chunks[i] == nil {
+			continue
+		}
+
+		if b.spill == nil {
+			var err error
+			b.spill, err = os.CreateTemp("", "srex-stream")
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := b.spill.WriteAt(b.chunks[i], (b.base+int64(i))*b.chunkSize); err != nil {
+			return err
+		}
+		b.memory -= int64(len(b.chunks[i]))
+		b.chunks[i] = nil
+	}
+	return nil
+}
+
+// copyAt copies the data at `off` that has already been read into p. It must be called with the lock held.
+func (b *Stream) copyAt(p []byte, off int64) (n int, err error) {
+	if off < b.base*b.chunkSize {
+		return 0, fmt.Errorf("Can't read offset %d of the stream since it has been released", off)
+	}
+
+	for n < len(p) && off < b.size {
+		i := off/b.chunkSize - b.base
+		within := off % b.chunkSize
+
+		var c int
+		if b.chunks[i] == nil {
+			l := b.chunkSize - within
+			if l > int64(len(p)-n) {
+				l = int64(len(p) - n)
+			}
+			c, err = b.spill.ReadAt(p[n:n+int(l)], off)
+			if err != nil {
+				return
+			}
+		} else {
+			c = copy(p[n:], b.chunks[i][within:])
+		}
+
+		n += c
+		off += int64(c)
+	}
+	return
+}
+
+// streamSectionReader reads part of a Stream, returning data as soon as it is available.
+type streamSectionReader struct {
+	b        *Stream
+	off, end int64
+}
+
+func (r *streamSectionReader) Read(p []byte) (n int, err error) {
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+
+	if max := r.end - r.off; int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	n, err = r.b.readAvailableAt(p, r.off)
+	r.off += int64(n)
+	return
+}
@@ -0,0 +1,169 @@
+package srex
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		maxMemory int64
+		release   int64
+		off       int64
+		n         int
+		expected  string
+		err       error
+	}{
+		{
+			name:      "start",
+			input:     "the quick brown fox",
+			maxMemory: 1024,
+			off:       0,
+			n:         9,
+			expected:  "the quick",
+		},
+		{
+			name:      "across chunks",
+			input:     "the quick brown fox",
+			maxMemory: 1024,
+			off:       2,
+			n:         11,
+			expected:  "e quick bro",
+		},
+		{
+			name:      "past end",
+			input:     "the quick brown fox",
+			maxMemory: 1024,
+			off:       16,
+			n:         5,
+			expected:  "fox",
+			err:       io.EOF,
+		},
+		{
+			name:      "spilled",
+			input:     "the quick brown fox",
+			maxMemory: 4,
+			off:       0,
+			n:         19,
+			expected:  "the quick brown fox",
+		},
+		{
+			name:      "after release",
+			input:     "the quick brown fox",
+			maxMemory: 1024,
+			release:   10,
+			off:       8,
+			n:         7,
+			expected:  "k brown",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewStream(strings.NewReader(tc.input))
+			b.chunkSize = 4
+			b.maxMemory = tc.maxMemory
+			defer b.Close()
+
+			if tc.release > 0 {
+				if _, err := b.ReadAt(make([]byte, tc.release), 0); err != nil {
+					t.Fatalf("Error reading before release: %v", err)
+				}
+				b.release(tc.release)
+			}
+
+			buf := make([]byte, tc.n)
+			n, err := b.ReadAt(buf, tc.off)
+			if err != tc.err {
+				t.Fatalf("Expected error %v but got %v", tc.err, err)
+			}
+
+			if string(buf[:n]) != tc.expected {
+				t.Fatalf("Actual '%s' does not match expected '%s'", string(buf[:n]), tc.expected)
+			}
+
+			size, err := b.Size()
+			if err != nil || size != int64(len(tc.input)) {
+				t.Fatalf("Expected size %d but got %d (%v)", len(tc.input), size, err)
+			}
+		})
+	}
+}
+
+func TestStreamBufferReleasedRead(t *testing.T) {
+	b := NewStream(strings.NewReader("the quick brown fox"))
+	b.chunkSize = 4
+	defer b.Close()
+
+	if _, err := b.Size(); err != nil {
+		t.Fatalf("Error reading stream: %v", err)
+	}
+	b.release(12)
+
+	if _, err := b.ReadAt(make([]byte, 2), 0); err == nil {
+		t.Fatalf("Expected an error reading released data")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that may be written and read from different goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestExecutorStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	input := NewStream(pr)
+	defer input.Close()
+
+	var out lockedBuffer
+	ex := newExecutor([]command{mustRegexpCommand('x', regexp.MustCompile(`[a-z]+\n`))})
+	ex.Sink = &WriterSink{Out: &out, Sep: "|"}
+
+	done := make(chan error)
+	go func() {
+		done <- ex.Go(input)
+	}()
+
+	// The regexp reads a few characters past the end of a match, so the first record is printed
+	// once some of the next one has arrived.
+	io.WriteString(pw, "one\ntwo")
+	deadline := time.Now().Add(5 * time.Second)
+	for out.String() != "one\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("First record was not printed before the end of the stream. Output is '%s'", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	io.WriteString(pw, "\nthree\n")
+	pw.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Error executing: %v", err)
+	}
+
+	expected := "one\n|two\n|three\n"
+	if out.String() != expected {
+		t.Fatalf("Actual '%s' does not match expected '%s'", out.String(), expected)
+	}
+}
@@ -0,0 +1,251 @@
+package srex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Template is the text output for each range by the f command or a TemplateSink, such as
+// `{file}:{line} {opc}`. Each placeholder in braces is replaced by:
+//
+//	{text}             the text of the range
+//	{file}             the name of the input
+//	{line} {end_line}  the numbers of the lines the range starts and ends on
+//	{start} {end}      the byte offsets of the start and end of the range
+//	{index}            the number of ranges output before this one, so the first is 0
+//	{0} {1} ...        the submatches of the last regexp that matched the range
+//	{name}             the submatch named name by any of the regexps, as in (?P<name>...)
+//
+// A submatch that didn't take part in the match is replaced by nothing. {{ and }} stand for
+// literal braces. The names of the placeholders above take priority over the names of submatches.
+//
+// A placeholder may be followed by filters that change its text, each after a |, such as
+// {text|oneline|trim}. The filters are applied from left to right:
+//
+//	trim     remove the white space at the start and end
+//	upper    convert to upper case
+//	lower    convert to lower case
+//	oneline  replace each run of white space that contains a newline with a single space, and
+//	         remove the white space at the start and end
+//	quote    quote as a Go or JSON string, with escapes for quotes and control characters
+type Template struct {
+	src   string
+	parts []templatePart
+}
+
+// templatePart is a piece of a Template: either literal text, or a placeholder for the field
+// named `field` if `field` isn't empty, whose text is changed by the `filters`.
+type templatePart struct {
+	literal string
+	field   string
+	filters []templateFilter
+}
+
+// templateFilter changes the text of a placeholder.
+type templateFilter func(text []byte) []byte
+
+// templateFilters are the filters that may follow a placeholder, by name.
+var templateFilters = map[string]templateFilter{
+	"trim":    bytes.TrimSpace,
+	"upper":   bytes.ToUpper,
+	"lower":   bytes.ToLower,
+	"oneline": oneLine,
+	"quote": func(text []byte) []byte {
+		return []byte(strconv.Quote(string(text)))
+	},
+}
+
+// oneLine replaces each run of ASCII white space in `text` that contains a newline with a space,
+// and trims the white space at the start and end, so that a line doesn't end with a space.
+func oneLine(text []byte) []byte {
+	var result []byte
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && strings.IndexByte(" \t\n\v\f\r", text[j]) >= 0 {
+			j++
+		}
+		if j == i {
+			result = append(result, text[i])
+			i++
+			continue
+		}
+		if bytes.IndexByte(text[i:j], '\n') >= 0 {
+			result = append(result, ' ')
+		} else {
+			result = append(result, text[i:j]...)
+		}
+		i = j
+	}
+	return bytes.TrimSpace(result)
+}
+
+// ParseTemplate parses the template `s`, returning an error if a placeholder isn't valid.
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{src: s}
+
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			lit.WriteByte(s[i])
+			i++
+		case s[i] == '{':
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				return nil, fmt.Errorf("Template has a '{' at character %d with no closing '}'", i+1)
+			}
+			part, err := parsePlaceholder(s[i+1 : i+j])
+			if err != nil {
+				return nil, err
+			}
+			if lit.Len() > 0 {
+				t.parts = append(t.parts, templatePart{literal: lit.String()})
+				lit.Reset()
+			}
+			t.parts = append(t.parts, part)
+			i += j
+		case s[i] == '}':
+			return nil, fmt.Errorf("Template has an unmatched '}' at character %d. Use }} for a literal brace", i+1)
+		default:
+			lit.WriteByte(s[i])
+		}
+	}
+	if lit.Len() > 0 {
+		t.parts = append(t.parts, templatePart{literal: lit.String()})
+	}
+	return t, nil
+}
+
+// parsePlaceholder parses the text between the braces of a placeholder: a field followed by
+// any number of filters.
+func parsePlaceholder(s string) (part templatePart, err error) {
+	names := strings.Split(s, "|")
+	part.field = names[0]
+	if err = validField(part.field); err != nil {
+		err = fmt.Errorf("Template placeholder '{%s}' is invalid: %v", s, err)
+		return
+	}
+
+	for _, name := range names[1:] {
+		f, ok := templateFilters[name]
+		if !ok {
+			err = fmt.Errorf("Template placeholder '{%s}' has an unknown filter '%s'. The filters are trim, upper, lower, oneline and quote", s, name)
+			return
+		}
+		part.filters = append(part.filters, f)
+	}
+	return
+}
+
+// MustParseTemplate is like ParseTemplate but panics if the template isn't valid.
+func MustParseTemplate(s string) *Template {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the source text of the template.
+func (t *Template) String() string {
+	return t.src
+}
+
+// templateInput is what the placeholders of a template refer to other than the range: the name
+// of the input, the index of its lines, and the number of ranges output before the range.
+type templateInput struct {
+	file  string
+	lines *lineIndex
+	index int64
+}
+
+// execute returns the text of the template for the range `m` of `data`.
+func (t *Template) execute(data io.ReaderAt, m Match, in templateInput) ([]byte, error) {
+	var buf []byte
+	for _, p := range t.parts {
+		if p.field == "" {
+			buf = append(buf, p.literal...)
+			continue
+		}
+
+		text, err := t.field(p.field, data, m, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range p.filters {
+			text = f(text)
+		}
+		buf = append(buf, text...)
+	}
+	return buf, nil
+}
+
+// field returns the text of the placeholder for `field`.
+func (t *Template) field(field string, data io.ReaderAt, m Match, in templateInput) ([]byte, error) {
+	switch field {
+	case "text":
+		return readRange(data, m.Start, m.End)
+	case "file":
+		return []byte(in.file), nil
+	case "line":
+		line, err := in.lines.line(m.Start)
+		return []byte(strconv.Itoa(line)), err
+	case "end_line":
+		line, err := in.lines.line(m.End)
+		return []byte(strconv.Itoa(line)), err
+	case "index":
+		return []byte(strconv.FormatInt(in.index, 10)), nil
+	case "start":
+		return []byte(strconv.FormatInt(m.Start, 10)), nil
+	case "end":
+		return []byte(strconv.FormatInt(m.End, 10)), nil
+	}
+
+	r, ok := m.Groups.Field(field)
+	if !ok {
+		return nil, nil
+	}
+	return readRange(data, r.Start, r.End)
+}
+
+// TemplateSink is a WriterSink that prints each range printed by p through a Template instead of
+// as it is, for the srex -o option. Each range is printed like the text of the f command.
+type TemplateSink struct {
+	WriterSink
+	Template *Template
+
+	lines   *lineIndex
+	printed int64
+}
+
+func (s *TemplateSink) Print(data io.ReaderAt, m Match) error {
+	buf, err := s.Template.execute(data, m, templateInput{file: s.File, lines: s.lines, index: s.printed})
+	if err != nil {
+		return err
+	}
+	s.printed++
+	return s.PrintText(m, buf)
+}
+
+func (s *TemplateSink) setLineIndex(x *lineIndex) {
+	s.lines = x
+}
+
+// inputName returns the name of the input that `sink` is the output for, which the {file}
+// placeholder is replaced by, or "" if the sink doesn't know it.
+func inputName(sink Sink) string {
+	switch s := sink.(type) {
+	case *WriterSink:
+		return s.File
+	case *TemplateSink:
+		return s.File
+	case *JSONSink:
+		return s.Name
+	case *contextSink:
+		return inputName(s.Sink)
+	}
+	return ""
+}
@@ -0,0 +1,28 @@
+package srex
+
+import (
+	"testing"
+)
+
+func TestOneLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "one line", input: "a  b", expected: "a  b"},
+		{name: "lines", input: "a\n  b\nc", expected: "a b c"},
+		{name: "ends with newline", input: "foo\n", expected: "foo"},
+		{name: "ends with spaces and newline", input: "foo \t\r\n", expected: "foo"},
+		{name: "starts with newline", input: "\n  foo\nbar\n", expected: "foo bar"},
+		{name: "empty", input: "", expected: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := string(oneLine([]byte(tc.input))); actual != tc.expected {
+				t.Fatalf("Expected %q but got %q", tc.expected, actual)
+			}
+		})
+	}
+}
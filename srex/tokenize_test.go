@@ -0,0 +1,276 @@
+package srex
+
+import (
+	"testing"
+)
+
+func TestTokenizeCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		output []string
+	}{
+		{
+			name:   "empty",
+			input:  "",
+			output: []string{},
+		},
+		{
+			name:   "single",
+			input:  "x/test/",
+			output: []string{"x/test/"},
+		},
+		{
+			name:   "double",
+			input:  "x/test/ y/blort/",
+			output: []string{"x/test/", "y/blort/"},
+		},
+		{
+			name:   "x, y then p",
+			input:  "x/test/ y/blort/ p",
+			output: []string{"x/test/", "y/blort/", "p"},
+		},
+		{
+			name:   "no space between",
+			input:  "x/test/y/blort/",
+			output: []string{"x/test/", "y/blort/"},
+		},
+		{
+			name:   "extra spaces",
+			input:  "x/test/      y/blort/",
+			output: []string{"x/test/", "y/blort/"},
+		},
+		{
+			name:   "space before regexp",
+			input:  "x /test/ g:opc /a/ s /a/b/",
+			output: []string{"x/test/", "g:opc/a/", "s/a/b/"},
+		},
+		{
+			name:   "space before n range",
+			input:  "x/test/ n [1:3] p",
+			output: []string{"x/test/", "n[1:3]", "p"},
+		},
+		{
+			name:   "space before comparison regexp",
+			input:  `x/test/ w /(\d+)/ > 5`,
+			output: []string{"x/test/", "w/(\\d+)/", ">", "5"},
+		},
+		{
+			name:   "order commands without regexp",
+			input:  "x/test/ o u r",
+			output: []string{"x/test/", "o", "u", "r"},
+		},
+		{
+			name:   "n command",
+			input:  "x/test/ n[1:3]",
+			output: []string{"x/test/", "n[1:3]"},
+		},
+		{
+			name:   "escape",
+			input:  `x/\//`,
+			output: []string{`x/\//`},
+		},
+		{
+			name:   "substitute",
+			input:  "x/test/ s/a/b/",
+			output: []string{"x/test/", "s/a/b/"},
+		},
+		{
+			name:   "substitute with flag",
+			input:  "x/test/ s/a/b/g",
+			output: []string{"x/test/", "s/a/b/g"},
+		},
+		{
+			name:   "substitute followed by g command",
+			input:  "s/a/b/g/c/",
+			output: []string{"s/a/b/", "g/c/"},
+		},
+		{
+			name:   "single letter commands",
+			input:  "x/test/ p =",
+			output: []string{"x/test/", "p", "="},
+		},
+		{
+			name:   "words",
+			input:  "x/test/ delete # print",
+			output: []string{"x/test/", "delete", "#", "print"},
+		},
+		{
+			name:   "format in block",
+			input:  "x/test/ { f/{text}: {a|upper}/ p }",
+			output: []string{"x/test/", "{", "f/{text}: {a|upper}/", "p", "}"},
+		},
+		{
+			name:   "aggregate",
+			input:  "x/test/ { k/(a)/ k:len/b/ }",
+			output: []string{"x/test/", "{", "k/(a)/", "k:len/b/", "}"},
+		},
+		{
+			name:   "order commands",
+			input:  "x/test/ o//nr o/(a)/n[1] u/b/ r",
+			output: []string{"x/test/", "o//nr", "o/(a)/", "n[1]", "u/b/", "r"},
+		},
+		{
+			name:   "comparison",
+			input:  `x/test/ w/(\d+)/ > 50 w/(\d+)/>=50`,
+			output: []string{"x/test/", "w/(\\d+)/", ">", "50", "w/(\\d+)/", ">=50"},
+		},
+		{
+			name:   "quoted comparison",
+			input:  `x/test/ w/: (.*)/ < "a \"b\" }" p`,
+			output: []string{"x/test/", "w/: (.*)/", "<", `"a \"b\" }"`, "p"},
+		},
+		{
+			name:   "location commands",
+			input:  "x/test/ { =# =+ }",
+			output: []string{"x/test/", "{", "=#", "=+", "}"},
+		},
+		{
+			name:   "block",
+			input:  "x/test/ { g/a/ p  v/b/ = }",
+			output: []string{"x/test/", "{", "g/a/", "p", "v/b/", "=", "}"},
+		},
+		{
+			name:   "block without spaces",
+			input:  "x/test/{g/a/p}",
+			output: []string{"x/test/", "{", "g/a/", "p", "}"},
+		},
+		{
+			name:   "braces in regexp",
+			input:  "x/a{2}/",
+			output: []string{"x/a{2}/"},
+		},
+		{
+			name:   "address",
+			input:  "/BEGIN/,/END\\//+1 x/test/",
+			output: []string{"/BEGIN/,/END\\//+1", "x/test/"},
+		},
+		{
+			name:   "address without space",
+			input:  "#10,$-2x/test/",
+			output: []string{"#10,$-2", "x/test/"},
+		},
+		{
+			name:   "count",
+			input:  "# x/test/ #",
+			output: []string{"#", "x/test/", "#"},
+		},
+		{
+			name:   "address then count",
+			input:  "#10,#20 #",
+			output: []string{"#10,#20", "#"},
+		},
+		{
+			name:   "substitute with escaped slash",
+			input:  `s/a\/b/c\//`,
+			output: []string{`s/a\/b/c\//`},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+
+			cmds := tokenizeCommands(tc.input)
+
+			if len(cmds) != len(tc.output) {
+				t.Fatalf("Expected %#v but got %#v'", tc.output, cmds)
+			}
+
+			for i, c := range tc.output {
+				if c != cmds[i] {
+					t.Fatalf("Expected %#v but got %#v'", tc.output, cmds)
+				}
+			}
+		})
+	}
+
+}
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		pipelines []int
+		err       bool
+	}{
+		{
+			name:      "no block",
+			input:     "x/test/ g/a/ p",
+			pipelines: nil,
+		},
+		{
+			name:      "block",
+			input:     "x/test/ { g/a/ p  v/b/ = }",
+			pipelines: []int{2, 2},
+		},
+		{
+			name:      "block with implicit print",
+			input:     "x/test/ { g/a/ x/b/ }",
+			pipelines: []int{2},
+		},
+		{
+			name:      "nested block",
+			input:     "x/test/ { g/a/ { p = } d }",
+			pipelines: []int{2, 1},
+		},
+		{
+			name:  "unterminated block",
+			input: "x/test/ { g/a/ p",
+			err:   true,
+		},
+		{
+			name:  "unmatched brace",
+			input: "x/test/ p }",
+			err:   true,
+		},
+		{
+			name:      "address",
+			input:     "10,20 x/test/",
+			pipelines: nil,
+		},
+		{
+			name:  "address not first",
+			input: "x/test/ 10,20",
+			err:   true,
+		},
+		{
+			name:  "command after block",
+			input: "x/test/ { p } p",
+			err:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmds, err := parseCommands(tc.input, nil)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("Expected an error parsing '%s'", tc.input)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Error parsing '%s': %v", tc.input, err)
+			}
+
+			block, ok := cmds[len(cmds)-1].(*blockCommand)
+			if tc.pipelines == nil {
+				if ok {
+					t.Fatalf("Expected no block but got one")
+				}
+				return
+			}
+
+			if !ok || len(block.pipelines) != len(tc.pipelines) {
+				t.Fatalf("Expected a block with %d pipelines but got %#v", len(tc.pipelines), cmds[len(cmds)-1])
+			}
+
+			for i, l := range tc.pipelines {
+				if len(block.pipelines[i]) != l {
+					t.Fatalf("Expected pipeline %d to have %d commands but it has %d", i, l, len(block.pipelines[i]))
+				}
+			}
+		})
+	}
+}